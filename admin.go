@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"harubot/admin"
+	decisionlog "harubot/decision-log"
+	"harubot/feature"
+	messagequeue "harubot/message-queue"
+	"harubot/moderation"
+	"harubot/pause"
+	personalmessagequeue "harubot/personal-message-queue"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	decisionLogCapacity  = 500
+	candidatesPerChannel = 3
+)
+
+// adminBot exposes the state to the admin API
+type adminBot struct {
+	state *state
+}
+
+func (b *adminBot) Status() admin.Status {
+	status := admin.Status{
+		Time:                b.state.clock.Now(),
+		Channels:            []admin.Channel{},
+		Outbound:            b.outbound(),
+		PersonalVelocity:    b.state.personalMessageQueue.Velocity(),
+		MaxPersonalVelocity: personalmessagequeue.MaxVelocity,
+		PausedGlobally:      b.state.isPaused(pause.Global),
+	}
+	for _, channel := range b.state.channels() {
+		mq, joined := b.state.messageQueue(channel)
+		if !joined {
+			continue // parted in the meantime
+		}
+		mq.Lock()
+		velocity := mq.Velocity()
+		messages := mq.Messages()
+		candidates := mq.Candidates(channel, candidatesPerChannel)
+		mq.Unlock()
+		queue := make([]admin.QueuedMessage, 0, len(messages))
+		for _, m := range messages {
+			queue = append(queue, admin.QueuedMessage{
+				Time:    m.Time,
+				User:    m.User.Name,
+				Message: m.Message,
+			})
+		}
+		status.Channels = append(status.Channels, admin.Channel{
+			Name:       channel,
+			Velocity:   velocity,
+			Paused:     b.state.isPaused(channel),
+			Live:       b.state.isLive(channel),
+			Modes:      b.state.roomStates.Get(channel),
+			Status:     b.state.userStates.Get(channel),
+			Thresholds: messagequeue.Thresholds(channel),
+			Queue:      queue,
+			Candidates: candidates,
+		})
+	}
+	return status
+}
+
+// outbound lists the pending autoreplies, soonest first
+func (b *adminBot) outbound() []admin.OutboundMessage {
+	b.state.pendingRepliesLock.Lock()
+	defer b.state.pendingRepliesLock.Unlock()
+	outbound := make([]admin.OutboundMessage, 0, len(b.state.pendingReplies))
+	for _, r := range b.state.pendingReplies {
+		outbound = append(outbound, admin.OutboundMessage{
+			Channel:     r.channel,
+			Description: fmt.Sprintf("autoreply to %s", r.user),
+			Due:         r.due,
+		})
+	}
+	sort.Slice(outbound, func(i, j int) bool {
+		return outbound[i].Due.Before(outbound[j].Due)
+	})
+	return outbound
+}
+
+func (b *adminBot) Emotes(channel string) ([]string, []string, error) {
+	return b.state.emoteCache.Emotes(channel)
+}
+
+func (b *adminBot) Decisions(limit int) []decisionlog.Decision {
+	return b.state.decisions.Recent(limit)
+}
+
+func (b *adminBot) Incidents() []moderation.Incident {
+	return b.state.moderation.Incidents()
+}
+
+func (b *adminBot) Features() []feature.Status {
+	return b.state.features.Statuses()
+}
+
+func (b *adminBot) Pause(channel string, d time.Duration) error {
+	channel = strings.ToLower(channel)
+	if _, joined := b.state.messageQueue(channel); channel != pause.Global && !joined {
+		return fmt.Errorf("not in #%s", channel)
+	}
+	b.state.pause(channel, d, "via admin api")
+	return nil
+}
+
+func (b *adminBot) Resume(channel string) error {
+	b.state.resume(strings.ToLower(channel), "via admin api")
+	return nil
+}
+
+func (b *adminBot) RefreshEmotes() {
+	b.state.emoteCache.RequestRefresh()
+}
+
+func (b *adminBot) Say(channel string, message string) error {
+	channel = strings.ToLower(channel)
+	if _, joined := b.state.messageQueue(channel); !joined {
+		return fmt.Errorf("not in #%s", channel)
+	}
+	if !b.state.hasBudget(channel) {
+		return errors.New("personal rate limit was hit")
+	}
+	b.state.say(channel, message)
+	return nil
+}
+
+func (b *adminBot) SetThreshold(channel string, wordCount int, value float32) error {
+	return messagequeue.SetThreshold(strings.ToLower(channel), wordCount, value)
+}
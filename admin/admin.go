@@ -0,0 +1,376 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	decisionlog "harubot/decision-log"
+	"harubot/feature"
+	messagequeue "harubot/message-queue"
+	"harubot/moderation"
+	roomstate "harubot/room-state"
+	userstate "harubot/user-state"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QueuedMessage is a chat message waiting in a channel's message queue
+type QueuedMessage struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Message string    `json:"message"`
+}
+
+type Channel struct {
+	Name       string                   `json:"name"`
+	Velocity   float64                  `json:"velocity"`
+	Paused     bool                     `json:"paused"`
+	Live       bool                     `json:"live"`
+	Modes      roomstate.Modes          `json:"modes"`
+	Status     userstate.Status         `json:"status"` // of the bot in the channel
+	Thresholds []float32                `json:"thresholds"`
+	Queue      []QueuedMessage          `json:"queue"`
+	Candidates []messagequeue.Candidate `json:"candidates"` // sentences closest to being echoed
+}
+
+// OutboundMessage is a message the bot is going to send once Due has passed
+type OutboundMessage struct {
+	Channel     string    `json:"channel"`
+	Description string    `json:"description"`
+	Due         time.Time `json:"due"`
+}
+
+type Status struct {
+	Time                time.Time         `json:"time"`
+	Channels            []Channel         `json:"channels"`
+	Outbound            []OutboundMessage `json:"outbound"`
+	PersonalVelocity    float64           `json:"personal-velocity"`
+	MaxPersonalVelocity float64           `json:"max-personal-velocity"`
+	PausedGlobally      bool              `json:"paused-globally"`
+}
+
+// Bot is what the admin API inspects and steers
+type Bot interface {
+	Status() Status
+	Emotes(channel string) (global []string, channelEmotes []string, err error)
+	Decisions(limit int) []decisionlog.Decision
+	Incidents() []moderation.Incident
+	Features() []feature.Status
+	Pause(channel string, d time.Duration) error // an empty channel pauses all of them, a d of 0 until resumed
+	Resume(channel string) error
+	RefreshEmotes()
+	Say(channel string, message string) error
+	SetThreshold(channel string, wordCount int, value float32) error
+}
+
+type Server struct {
+	bot   Bot
+	token string // required as bearer token of every request unless empty
+	mux   *http.ServeMux
+}
+
+// NewServer creates a server for bot. Without a token it only listens on loopback addresses and unix sockets.
+func NewServer(bot Bot, token string) *Server {
+	s := &Server{
+		bot:   bot,
+		token: token,
+		mux:   http.NewServeMux(),
+	}
+	s.mux.HandleFunc("/status", s.get(s.status))
+	s.mux.HandleFunc("/emotes", s.get(s.emotes))
+	s.mux.HandleFunc("/decisions", s.get(s.decisions))
+	s.mux.HandleFunc("/incidents", s.get(s.incidents))
+	s.mux.HandleFunc("/features", s.get(s.features))
+	s.mux.HandleFunc("/pause", s.post(s.pause))
+	s.mux.HandleFunc("/resume", s.post(s.resume))
+	s.mux.HandleFunc("/emotes/refresh", s.post(s.refreshEmotes))
+	s.mux.HandleFunc("/say", s.post(s.say))
+	s.mux.HandleFunc("/threshold", s.post(s.threshold))
+	return s
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		given := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(given), []byte(s.token)) != 1 {
+			w.Header().Set("Content-Type", "application/json")
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "missing or wrong bearer token"})
+			return
+		}
+	}
+	if s.token == "" && !isLocalHost(r.Host) {
+		// a site whose name resolves to 127.0.0.1 could otherwise steer the bot through the browser of whoever runs it
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "only localhost may be used as host without a token"})
+		return
+	}
+	if r.Method != http.MethodGet && isCrossOrigin(r) {
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "cross-origin requests aren't allowed"})
+		return
+	}
+	s.mux.ServeHTTP(w, r)
+}
+
+// isCrossOrigin tells whether a browser sent r on behalf of another site, which must not steer the bot
+func isCrossOrigin(r *http.Request) bool {
+	if site := r.Header.Get("Sec-Fetch-Site"); site != "" && site != "same-origin" && site != "none" {
+		return true
+	}
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return false // not sent by a browser
+	}
+	u, err := url.Parse(origin)
+	return err != nil || u.Host != r.Host
+}
+
+// unixSocketHost is the host Client sends requests over a unix socket to
+const unixSocketHost = "harubot"
+
+// isLocalHost tells whether the Host header of a request names this machine, or is the one sent over unix sockets
+func isLocalHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" || host == unixSocketHost {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLoopback tells whether a host:port address only accepts connections from this machine
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Listen opens address, either host:port or unix:<path> for a unix socket only the bot's user can connect to
+func Listen(address string) (net.Listener, error) {
+	if !strings.HasPrefix(address, "unix:") {
+		return net.Listen("tcp", address)
+	}
+	path := strings.TrimPrefix(address, "unix:")
+	err := os.Remove(path) // left behind if the bot didn't shut down cleanly
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Serve answers requests on address until ctx is cancelled
+func (s *Server) Serve(ctx context.Context, address string) error {
+	if s.token == "" && !strings.HasPrefix(address, "unix:") && !isLoopback(address) {
+		return fmt.Errorf("refusing to listen on %s without a token, use a loopback address, a unix socket or set a token", address)
+	}
+	listener, err := Listen(address)
+	if err != nil {
+		return err
+	}
+	server := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(shutdownCtx)
+	}()
+	log.Infof("admin api listening on %s", address)
+	err = server.Serve(listener)
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// handler returns the value to respond with as JSON, or an error to respond with as status
+type handler func(r *http.Request) (any, error)
+
+type statusError struct {
+	status  int
+	message string
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+func badRequest(format string, args ...any) error {
+	return &statusError{status: http.StatusBadRequest, message: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) get(h handler) http.HandlerFunc {
+	return s.handle(http.MethodGet, h)
+}
+
+func (s *Server) post(h handler) http.HandlerFunc {
+	return s.handle(http.MethodPost, h)
+}
+
+func (s *Server) handle(method string, h handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != method {
+			w.Header().Set("Allow", method)
+			writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+			return
+		}
+		response, err := h(r)
+		if err != nil {
+			status := http.StatusInternalServerError
+			var se *statusError
+			if errors.As(err, &se) {
+				status = se.status
+			}
+			writeJSON(w, status, map[string]string{"error": err.Error()})
+			return
+		}
+		if response == nil {
+			response = map[string]bool{"ok": true}
+		}
+		writeJSON(w, http.StatusOK, response)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Errorf("failed to write admin api response: %s", err)
+	}
+}
+
+func (s *Server) status(r *http.Request) (any, error) {
+	return s.bot.Status(), nil
+}
+
+func (s *Server) emotes(r *http.Request) (any, error) {
+	channel := r.URL.Query().Get("channel")
+	global, channelEmotes, err := s.bot.Emotes(channel)
+	if err != nil {
+		return nil, badRequest("%s", err)
+	}
+	return map[string][]string{
+		"global-emotes":  global,
+		"channel-emotes": channelEmotes,
+	}, nil
+}
+
+func (s *Server) decisions(r *http.Request) (any, error) {
+	limit := 0
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil {
+			return nil, badRequest("invalid limit %q", l)
+		}
+	}
+	return s.bot.Decisions(limit), nil
+}
+
+func (s *Server) incidents(r *http.Request) (any, error) {
+	return s.bot.Incidents(), nil
+}
+
+func (s *Server) features(r *http.Request) (any, error) {
+	return s.bot.Features(), nil
+}
+
+func (s *Server) pause(r *http.Request) (any, error) {
+	var d time.Duration
+	if duration := r.URL.Query().Get("duration"); duration != "" {
+		var err error
+		d, err = time.ParseDuration(duration)
+		if err != nil || d < 0 {
+			return nil, badRequest("invalid duration %q", duration)
+		}
+	}
+	err := s.bot.Pause(r.URL.Query().Get("channel"), d)
+	if err != nil {
+		return nil, badRequest("%s", err)
+	}
+	return nil, nil
+}
+
+func (s *Server) resume(r *http.Request) (any, error) {
+	err := s.bot.Resume(r.URL.Query().Get("channel"))
+	if err != nil {
+		return nil, badRequest("%s", err)
+	}
+	return nil, nil
+}
+
+func (s *Server) refreshEmotes(r *http.Request) (any, error) {
+	s.bot.RefreshEmotes()
+	return nil, nil
+}
+
+type sayRequest struct {
+	Channel string `json:"channel"`
+	Message string `json:"message"`
+}
+
+func (s *Server) say(r *http.Request) (any, error) {
+	req := sayRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, badRequest("invalid body: %s", err)
+	}
+	if req.Channel == "" || strings.TrimSpace(req.Message) == "" {
+		return nil, badRequest("channel and message are required")
+	}
+	err = s.bot.Say(req.Channel, req.Message)
+	if err != nil {
+		return nil, badRequest("%s", err)
+	}
+	return nil, nil
+}
+
+type thresholdRequest struct {
+	Channel   string  `json:"channel"`
+	WordCount int     `json:"word-count"`
+	Value     float32 `json:"value"`
+}
+
+func (s *Server) threshold(r *http.Request) (any, error) {
+	req := thresholdRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return nil, badRequest("invalid body: %s", err)
+	}
+	if req.Channel == "" {
+		return nil, badRequest("channel is required")
+	}
+	err = s.bot.SetThreshold(req.Channel, req.WordCount, req.Value)
+	if err != nil {
+		return nil, badRequest("%s", err)
+	}
+	return nil, nil
+}
@@ -0,0 +1,162 @@
+package admin
+
+import (
+	"errors"
+	decisionlog "harubot/decision-log"
+	"harubot/feature"
+	"harubot/moderation"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeBot struct {
+	paused map[string]bool
+	said   []string
+}
+
+func (b *fakeBot) Status() Status {
+	return Status{Channels: []Channel{{Name: "xqc", Velocity: 1.5}}}
+}
+
+func (b *fakeBot) Emotes(channel string) ([]string, []string, error) {
+	if channel != "xqc" {
+		return nil, nil, errors.New("unknown channel")
+	}
+	return []string{"Kappa"}, []string{"xqcL"}, nil
+}
+
+func (b *fakeBot) Decisions(limit int) []decisionlog.Decision {
+	return []decisionlog.Decision{}
+}
+
+func (b *fakeBot) Incidents() []moderation.Incident {
+	return []moderation.Incident{}
+}
+
+func (b *fakeBot) Features() []feature.Status {
+	return []feature.Status{}
+}
+
+func (b *fakeBot) Pause(channel string, d time.Duration) error {
+	b.paused[channel] = true
+	return nil
+}
+
+func (b *fakeBot) Resume(channel string) error {
+	b.paused[channel] = false
+	return nil
+}
+
+func (b *fakeBot) RefreshEmotes() {}
+
+func (b *fakeBot) Say(channel string, message string) error {
+	b.said = append(b.said, channel+": "+message)
+	return nil
+}
+
+func (b *fakeBot) SetThreshold(channel string, wordCount int, value float32) error {
+	return errors.New("word count has to be between 1 and 3")
+}
+
+func TestServer(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{"status", http.MethodGet, "/status", "", http.StatusOK, `"velocity":1.5`},
+		{"wrong method", http.MethodPost, "/status", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"emotes", http.MethodGet, "/emotes?channel=xqc", "", http.StatusOK, `"channel-emotes":["xqcL"]`},
+		{"emotes of unknown channel", http.MethodGet, "/emotes?channel=nope", "", http.StatusBadRequest, "unknown channel"},
+		{"invalid decisions limit", http.MethodGet, "/decisions?limit=x", "", http.StatusBadRequest, "invalid limit"},
+		{"pause", http.MethodPost, "/pause?channel=xqc&duration=10m", "", http.StatusOK, `"ok":true`},
+		{"pause with invalid duration", http.MethodPost, "/pause?channel=forsen&duration=soon", "", http.StatusBadRequest, "invalid duration"},
+		{"say", http.MethodPost, "/say", `{"channel":"xqc","message":"hi"}`, http.StatusOK, `"ok":true`},
+		{"say without message", http.MethodPost, "/say", `{"channel":"xqc"}`, http.StatusBadRequest, "required"},
+		{"invalid threshold", http.MethodPost, "/threshold", `{"channel":"xqc","word-count":4,"value":3}`, http.StatusBadRequest, "word count"},
+	}
+	bot := &fakeBot{paused: map[string]bool{}}
+	server := NewServer(bot, "")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			r.Host = "localhost:8081"
+			server.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+			if !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("body = %s, want it to contain %s", w.Body.String(), tt.wantBody)
+			}
+		})
+	}
+	if !bot.paused["xqc"] || len(bot.said) != 1 {
+		t.Errorf("bot wasn't steered: paused %v, said %v", bot.paused, bot.said)
+	}
+}
+
+func TestServer_Guards(t *testing.T) {
+	tests := []struct {
+		name       string
+		method     string
+		token      string
+		host       string
+		header     map[string]string
+		wantStatus int
+	}{
+		{"same origin", http.MethodPost, "", "127.0.0.1:8081", map[string]string{"Origin": "http://127.0.0.1:8081"}, http.StatusOK},
+		{"cross origin", http.MethodPost, "", "127.0.0.1:8081", map[string]string{"Origin": "http://evil.example"}, http.StatusForbidden},
+		{"cross site fetch", http.MethodPost, "", "localhost:8081", map[string]string{"Sec-Fetch-Site": "cross-site"}, http.StatusForbidden},
+		{"unix socket", http.MethodPost, "", unixSocketHost, nil, http.StatusOK},
+		{"dns rebinding", http.MethodPost, "", "evil.example:8081", map[string]string{"Origin": "http://evil.example:8081", "Sec-Fetch-Site": "same-origin"}, http.StatusForbidden},
+		{"dns rebinding read", http.MethodGet, "", "evil.example:8081", map[string]string{"Sec-Fetch-Site": "same-origin"}, http.StatusForbidden},
+		{"foreign host", http.MethodPost, "", "evil.example", nil, http.StatusForbidden},
+		{"foreign host with token", http.MethodPost, "secret", "bot.example:8081", map[string]string{"Authorization": "Bearer secret"}, http.StatusOK},
+		{"missing token", http.MethodPost, "secret", "localhost", nil, http.StatusUnauthorized},
+		{"wrong token", http.MethodPost, "secret", "localhost", map[string]string{"Authorization": "Bearer guess"}, http.StatusUnauthorized},
+		{"right token", http.MethodPost, "secret", "localhost", map[string]string{"Authorization": "Bearer secret"}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bot := &fakeBot{paused: map[string]bool{}}
+			server := NewServer(bot, tt.token)
+			r := httptest.NewRequest(tt.method, "/resume?channel=xqc", nil)
+			r.Host = tt.host
+			for key, value := range tt.header {
+				r.Header.Set(key, value)
+			}
+			w := httptest.NewRecorder()
+			server.ServeHTTP(w, r)
+			if w.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", w.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		{"127.0.0.1:8081", true},
+		{"localhost:8081", true},
+		{"[::1]:8081", true},
+		{"0.0.0.0:8081", false},
+		{":8081", false},
+		{"192.168.1.2:8081", false},
+		{"nonsense", false},
+	}
+	for _, tt := range tests {
+		if got := isLoopback(tt.address); got != tt.want {
+			t.Errorf("isLoopback(%q) = %v, want %v", tt.address, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	decisionlog "harubot/decision-log"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Client talks to the admin API of a running bot
+type Client struct {
+	baseURL    string
+	token      string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the admin API at address, in the same format Listen takes, sending token if it isn't empty
+func NewClient(address string, token string) *Client {
+	c := &Client{
+		baseURL:    fmt.Sprintf("http://%s", address),
+		token:      token,
+		httpClient: &http.Client{Timeout: 5 * time.Second},
+	}
+	if strings.HasPrefix(address, "unix:") {
+		path := strings.TrimPrefix(address, "unix:")
+		c.baseURL = "http://" + unixSocketHost
+		c.httpClient.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", path)
+			},
+		}
+	}
+	return c
+}
+
+func (c *Client) get(ctx context.Context, path string, response any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("admin api responded with %d: %s", resp.StatusCode, body)
+	}
+	return json.Unmarshal(body, response)
+}
+
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	status := &Status{}
+	err := c.get(ctx, "/status", status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func (c *Client) Decisions(ctx context.Context, limit int) ([]decisionlog.Decision, error) {
+	decisions := []decisionlog.Decision{}
+	err := c.get(ctx, fmt.Sprintf("/decisions?limit=%d", limit), &decisions)
+	if err != nil {
+		return nil, err
+	}
+	return decisions, nil
+}
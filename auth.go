@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"harubot/clock"
+	"harubot/credentials"
+	deviceauth "harubot/device-auth"
+	renewusertoken "harubot/renew-user-token"
+	"os"
+	"os/signal"
+)
+
+// runAuth authorizes the bot's account through the device code flow and stores the resulting tokens
+func runAuth(args []string) error {
+	flags := flag.NewFlagSet("auth", flag.ExitOnError)
+	secretsPath := flags.String("secrets", "./secrets.json", "path to the secrets file the tokens are written to")
+	clientID := flags.String("client-id", "", "client ID of your Twitch application, defaults to the one in the secrets")
+	clientSecret := flags.String("client-secret", "", "client secret of your Twitch application, defaults to the one in the secrets")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	store, err := credentials.OpenStore(*secretsPath)
+	if err != nil {
+		return err
+	}
+	if store == nil {
+		return errors.New("no secrets file given, set -secrets")
+	}
+
+	s, err := store.Load()
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read existing secrets: %s", err)
+	}
+	if *clientID != "" {
+		s.TwitchClientID = *clientID
+	}
+	if *clientSecret != "" {
+		s.TwitchClientSecret = *clientSecret
+	}
+	if s.TwitchClientID == "" {
+		return errors.New("no client ID in the secrets, set -client-id")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	clk := clock.New()
+	flow := deviceauth.NewFlow(deviceauth.Config{
+		ClientID:     s.TwitchClientID,
+		ClientSecret: s.TwitchClientSecret,
+	}, clk)
+	authorization, err := flow.Start(ctx)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Log in as the bot's account and enter the code %s at %s\n", authorization.UserCode, authorization.VerificationURI)
+	token, err := flow.Wait(ctx, authorization)
+	if err != nil {
+		return err
+	}
+
+	validation, err := renewusertoken.NewRefresher(renewusertoken.Config{}, credentials.NewProvider(s, nil), clk).Validate(ctx, token.AccessToken)
+	if err != nil {
+		return fmt.Errorf("failed to validate new token: %s", err)
+	}
+	s.Username = validation.Login
+	s.TwitchAccessToken = token.AccessToken
+	s.TwitchRefreshToken = token.RefreshToken
+	s.OauthKey = "" // chat logs in with the user token from now on
+	err = store.Save(s)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Saved tokens for %s\n", validation.Login)
+	return nil
+}
@@ -0,0 +1,178 @@
+package chatlog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const dayLayout = "2006-01-02"
+
+// Recorder writes raw incoming IRC lines to one file per channel and day,
+// starting a new file whenever the current one grows past maxBytes
+type Recorder struct {
+	directory string
+	maxBytes  int64
+	files     map[string]*logFile
+	lock      sync.Mutex
+}
+
+type logFile struct {
+	file  *os.File
+	day   string
+	index int
+	size  int64
+}
+
+func NewRecorder(directory string, maxBytes int64) (*Recorder, error) {
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return nil, err
+	}
+	return &Recorder{
+		directory: directory,
+		maxBytes:  maxBytes,
+		files:     map[string]*logFile{},
+	}, nil
+}
+
+// Record appends a raw line received at t to the log of channel
+func (r *Recorder) Record(channel string, t time.Time, raw string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	line := FormatLine(Line{Time: t, Raw: raw})
+	lf, err := r.fileFor(channel, t, int64(len(line)))
+	if err != nil {
+		return err
+	}
+	n, err := lf.file.WriteString(line)
+	lf.size += int64(n)
+	return err
+}
+
+// fileFor returns the file the next line of channel should be written to, rotating if necessary
+func (r *Recorder) fileFor(channel string, t time.Time, lineSize int64) (*logFile, error) {
+	day := t.UTC().Format(dayLayout)
+	lf, ok := r.files[channel]
+	if ok && lf.day == day && (r.maxBytes <= 0 || lf.size+lineSize <= r.maxBytes) {
+		return lf, nil
+	}
+
+	index := 0
+	if ok {
+		err := lf.file.Close()
+		if err != nil {
+			return nil, err
+		}
+		if lf.day == day {
+			index = lf.index + 1
+		}
+	}
+
+	channelDirectory := filepath.Join(r.directory, channel)
+	err := os.MkdirAll(channelDirectory, 0755)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		path := filepath.Join(channelDirectory, fileName(day, index))
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		if r.maxBytes > 0 && info.Size() > 0 && info.Size()+lineSize > r.maxBytes {
+			file.Close()
+			index++ // file left over from a previous run is already full
+			continue
+		}
+		lf = &logFile{
+			file:  file,
+			day:   day,
+			index: index,
+			size:  info.Size(),
+		}
+		r.files[channel] = lf
+		return lf, nil
+	}
+}
+
+func fileName(day string, index int) string {
+	if index == 0 {
+		return fmt.Sprintf("%s.log", day)
+	}
+	return fmt.Sprintf("%s.%d.log", day, index)
+}
+
+func (r *Recorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	var firstErr error
+	for channel, lf := range r.files {
+		err := lf.file.Close()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(r.files, channel)
+	}
+	return firstErr
+}
+
+// Line is a single recorded IRC line along with the time it was received
+type Line struct {
+	Time time.Time
+	Raw  string
+}
+
+// FormatLine serializes a line as "<unix milliseconds> <raw line>\n"
+func FormatLine(l Line) string {
+	return fmt.Sprintf("%d %s\n", l.Time.UnixMilli(), l.Raw)
+}
+
+func ParseLine(s string) (Line, error) {
+	timestamp, raw, found := strings.Cut(s, " ")
+	if !found {
+		return Line{}, fmt.Errorf("malformed chat log line: %q", s)
+	}
+	millis, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return Line{}, fmt.Errorf("malformed chat log timestamp: %s", err)
+	}
+	return Line{
+		Time: time.UnixMilli(millis),
+		Raw:  raw,
+	}, nil
+}
+
+// ReadLines calls onLine for every line in r, in order, stopping at the first error
+func ReadLines(r io.Reader, onLine func(Line) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		if text == "" {
+			continue
+		}
+		l, err := ParseLine(text)
+		if err != nil {
+			return err
+		}
+		err = onLine(l)
+		if err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
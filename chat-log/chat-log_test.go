@@ -0,0 +1,106 @@
+package chatlog
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLine_RoundTrip(t *testing.T) {
+	lines := []Line{
+		{Time: time.UnixMilli(1654084800123), Raw: "@badge-info=;color=#FF0000 :forsen!forsen@forsen.tmi.twitch.tv PRIVMSG #xqc :KEKW KEKW"},
+		{Time: time.UnixMilli(1654084800124), Raw: ":tmi.twitch.tv CLEARCHAT #xqc"},
+		{Time: time.UnixMilli(1654084800125), Raw: "@msg-id=msg_banned :tmi.twitch.tv NOTICE #xqc :You are permanently banned from talking in xqc."},
+	}
+	for _, l := range lines {
+		got, err := ParseLine(strings.TrimSuffix(FormatLine(l), "\n"))
+		if err != nil {
+			t.Fatalf("ParseLine(FormatLine(%v)) failed: %s", l, err)
+		}
+		if !got.Time.Equal(l.Time) || got.Raw != l.Raw {
+			t.Errorf("ParseLine(FormatLine(%v)) = %v", l, got)
+		}
+	}
+}
+
+func TestParseLine_Malformed(t *testing.T) {
+	for _, s := range []string{"no-timestamp", "soon :tmi.twitch.tv PING"} {
+		if _, err := ParseLine(s); err == nil {
+			t.Errorf("ParseLine(%q) succeeded, want an error", s)
+		}
+	}
+}
+
+func TestRecorder_RoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	r, err := NewRecorder(dir, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	day := time.Date(2022, 6, 1, 23, 59, 0, 0, time.UTC)
+	recorded := map[string][]Line{
+		"xqc": {
+			{Time: day, Raw: ":forsen!forsen@forsen.tmi.twitch.tv PRIVMSG #xqc :KEKW"},
+			{Time: day.Add(time.Second), Raw: ":forsen!forsen@forsen.tmi.twitch.tv PRIVMSG #xqc :a message long enough to rotate"},
+			{Time: day.Add(2 * time.Minute), Raw: ":tmi.twitch.tv CLEARCHAT #xqc"},
+		},
+		"forsen": {
+			{Time: day, Raw: ":xqc!xqc@xqc.tmi.twitch.tv PRIVMSG #forsen :LULW"},
+		},
+	}
+	for channel, lines := range recorded {
+		for _, l := range lines {
+			err := r.Record(channel, l.Time, l.Raw)
+			if err != nil {
+				t.Fatalf("Record() failed: %s", err)
+			}
+		}
+	}
+	err = r.Close()
+	if err != nil {
+		t.Fatalf("Close() failed: %s", err)
+	}
+
+	wantFiles := map[string]int{
+		"xqc/2022-06-01.log":    1,
+		"xqc/2022-06-01.1.log":  1,
+		"xqc/2022-06-02.log":    1,
+		"forsen/2022-06-01.log": 1,
+	}
+	read := map[string][]Line{}
+	for file, wantLines := range wantFiles {
+		f, err := os.Open(filepath.Join(dir, file))
+		if err != nil {
+			t.Fatalf("expected log file %s: %s", file, err)
+		}
+		channel := filepath.Dir(file)
+		count := 0
+		err = ReadLines(f, func(l Line) error {
+			read[channel] = append(read[channel], l)
+			count++
+			return nil
+		})
+		f.Close()
+		if err != nil {
+			t.Fatalf("ReadLines(%s) failed: %s", file, err)
+		}
+		if count != wantLines {
+			t.Errorf("%s has %d lines, want %d", file, count, wantLines)
+		}
+	}
+	for _, lines := range read {
+		sort.SliceStable(lines, func(i, j int) bool {
+			return lines[i].Time.Before(lines[j].Time)
+		})
+		for i := range lines {
+			lines[i].Time = lines[i].Time.UTC()
+		}
+	}
+	if !reflect.DeepEqual(read, recorded) {
+		t.Errorf("read back %v, want %v", read, recorded)
+	}
+}
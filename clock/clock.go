@@ -0,0 +1,152 @@
+package clock
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time for everything that waits or measures time, so tests and replays can use virtual time
+type Clock interface {
+	Now() time.Time
+	Since(t time.Time) time.Duration
+	Sleep(d time.Duration)
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+// New returns a clock backed by the system time
+func New() Clock {
+	return realClock{}
+}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) Since(t time.Time) time.Duration        { return time.Since(t) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Virtual is a clock that only moves when told to, waking sleepers whose deadline has passed
+type Virtual struct {
+	now     time.Time
+	waiters []waiter
+	lock    sync.Mutex
+}
+
+type waiter struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func NewVirtual(start time.Time) *Virtual {
+	return &Virtual{
+		now: start,
+	}
+}
+
+func (v *Virtual) Now() time.Time {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return v.now
+}
+
+func (v *Virtual) Since(t time.Time) time.Duration {
+	return v.Now().Sub(t)
+}
+
+func (v *Virtual) Sleep(d time.Duration) {
+	<-v.After(d)
+}
+
+func (v *Virtual) After(d time.Duration) <-chan time.Time {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- v.now
+		return c
+	}
+	v.waiters = append(v.waiters, waiter{
+		deadline: v.now.Add(d),
+		c:        c,
+	})
+	return c
+}
+
+// Advance moves the clock forward by d
+func (v *Virtual) Advance(d time.Duration) {
+	v.Set(v.Now().Add(d))
+}
+
+// Set moves the clock to t and wakes all sleepers whose deadline is at or before t, earliest first.
+// Moving the clock backwards is ignored.
+func (v *Virtual) Set(t time.Time) {
+	v.lock.Lock()
+	if t.Before(v.now) {
+		v.lock.Unlock()
+		return
+	}
+	v.now = t
+	due := []waiter{}
+	pending := []waiter{}
+	for _, w := range v.waiters {
+		if w.deadline.After(t) {
+			pending = append(pending, w)
+		} else {
+			due = append(due, w)
+		}
+	}
+	v.waiters = pending
+	v.lock.Unlock()
+
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].deadline.Before(due[j].deadline)
+	})
+	for _, w := range due {
+		w.c <- t
+	}
+}
+
+// AdvanceToNextWaiter moves the clock to the earliest pending deadline, returning false if nobody is waiting
+func (v *Virtual) AdvanceToNextWaiter() bool {
+	next, ok := v.NextDeadline()
+	if !ok {
+		return false
+	}
+	v.Set(next)
+	return true
+}
+
+// NextDeadline returns the earliest pending deadline, or false if nobody is waiting
+func (v *Virtual) NextDeadline() (time.Time, bool) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	if len(v.waiters) == 0 {
+		return time.Time{}, false
+	}
+	next := v.waiters[0].deadline
+	for _, w := range v.waiters[1:] {
+		if w.deadline.Before(next) {
+			next = w.deadline
+		}
+	}
+	return next, true
+}
+
+// Waiters returns the number of pending sleepers
+func (v *Virtual) Waiters() int {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	return len(v.waiters)
+}
+
+// SleepContext sleeps for d on clk, returning the context's error early if ctx is done first
+func SleepContext(ctx context.Context, clk Clock, d time.Duration) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-clk.After(d):
+		return nil
+	}
+}
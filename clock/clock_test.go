@@ -0,0 +1,66 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtual_Set(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	v := NewVirtual(start)
+	early := v.After(5 * time.Second)
+	late := v.After(20 * time.Second)
+
+	v.Advance(10 * time.Second)
+	select {
+	case <-early:
+	default:
+		t.Errorf("After(5s) did not fire after advancing 10s")
+	}
+	select {
+	case <-late:
+		t.Errorf("After(20s) fired after advancing 10s")
+	default:
+	}
+	if got := v.Waiters(); got != 1 {
+		t.Errorf("Waiters() = %d, want 1", got)
+	}
+
+	v.Set(start) // moving backwards is ignored
+	if got, want := v.Since(start), 10*time.Second; got != want {
+		t.Errorf("Since() = %s, want %s", got, want)
+	}
+
+	if next, ok := v.NextDeadline(); !ok || !next.Equal(start.Add(20*time.Second)) {
+		t.Errorf("NextDeadline() = %s, %v, want %s", next, ok, start.Add(20*time.Second))
+	}
+	if !v.AdvanceToNextWaiter() {
+		t.Fatalf("AdvanceToNextWaiter() = false, want true")
+	}
+	<-late
+	if got, want := v.Now(), start.Add(20*time.Second); !got.Equal(want) {
+		t.Errorf("Now() = %s, want %s", got, want)
+	}
+	if v.AdvanceToNextWaiter() {
+		t.Errorf("AdvanceToNextWaiter() = true without waiters, want false")
+	}
+	if _, ok := v.NextDeadline(); ok {
+		t.Errorf("NextDeadline() = true without waiters, want false")
+	}
+}
+
+func TestVirtual_Sleep(t *testing.T) {
+	v := NewVirtual(time.Unix(0, 0))
+	woke := make(chan time.Time)
+	go func() {
+		v.Sleep(time.Minute)
+		woke <- v.Now()
+	}()
+	for v.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	v.Advance(time.Minute)
+	if got := <-woke; !got.Equal(time.Unix(60, 0)) {
+		t.Errorf("Sleep() woke at %s, want %s", got, time.Unix(60, 0))
+	}
+}
@@ -1,9 +1,17 @@
 package colorstate
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	log "github.com/sirupsen/logrus"
+	"harubot/clock"
 	personalmessagequeue "harubot/personal-message-queue"
+	twitchapi "harubot/twitch-api"
+	"net/http"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -12,48 +20,172 @@ const (
 	DESCENDING
 )
 
+// ColorSetter changes the bot's chat color
+type ColorSetter interface {
+	SetColor(ctx context.Context, color string) error
+}
+
+// HelixColorSetter changes the color through the Update User Chat Color endpoint
+type HelixColorSetter struct {
+	API    *twitchapi.Client
+	UserID string
+}
+
+func (h *HelixColorSetter) SetColor(ctx context.Context, color string) error {
+	err := h.API.UpdateUserChatColor(ctx, h.UserID, color)
+	apiErr := &twitchapi.Error{}
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusBadRequest && strings.Contains(strings.ToLower(apiErr.Message), "turbo") {
+		return fmt.Errorf("%w: %s", ErrHexColorNotAllowed, err)
+	}
+	return err
+}
+
+// ErrHexColorNotAllowed is returned by setters when the account needs Turbo or Prime for hex colors
+var ErrHexColorNotAllowed = errors.New("hex colors need Turbo or Prime")
+
+// Sayer sends chat messages, implemented by twitchirc.Client
+type Sayer interface {
+	Say(channel string, text string)
+}
+
+var _ Sayer = (*twitchirc.Client)(nil)
+
+// IRCColorSetter changes the color with the deprecated /color chat command, which counts towards the outbound rate limit
+type IRCColorSetter struct {
+	Client       Sayer
+	SelfUsername string
+	PMQ          *personalmessagequeue.PersonalMessageQueue
+	Clock        clock.Clock
+}
+
+func (i *IRCColorSetter) SetColor(ctx context.Context, color string) error {
+	if !i.PMQ.HasBudget() {
+		return fmt.Errorf("personal rate limit was hit")
+	}
+	i.Client.Say(i.SelfUsername, fmt.Sprintf("/color %s", ircColorName(color)))
+	i.PMQ.Push(i.Clock.Now())
+	return nil
+}
+
+// capabilities of the bot's account regarding hex colors
+const (
+	hexColorsUnknown = iota
+	hexColorsAllowed
+	hexColorsNotAllowed
+)
+
 type ColorState struct {
-	direction  int
-	colorIndex int
-	colors     []string
+	hexColors     int
+	engine        *Engine
+	setter        ColorSetter
+	fallback      ColorSetter // used if setter fails, may be nil
+	interval      time.Duration
+	beforeMessage bool
+	clock         clock.Clock
+	lock          sync.Mutex
 }
 
-func NewColorState(colors []string) *ColorState {
+// NewColorState changes color every interval, unless it's 0, and before every message if beforeMessage is set
+func NewColorState(engine *Engine, interval time.Duration, beforeMessage bool, setter ColorSetter, fallback ColorSetter, clk clock.Clock) *ColorState {
 	c := &ColorState{
-		direction:  ASCENDING,
-		colorIndex: 0,
-		colors:     colors,
+		engine:        engine,
+		setter:        setter,
+		fallback:      fallback,
+		interval:      interval,
+		beforeMessage: beforeMessage,
+		clock:         clk,
 	}
 	return c
 }
 
-func (c *ColorState) getColor() string {
-	return c.colors[c.colorIndex]
+// changeColor switches to the next color, one change at a time
+func (c *ColorState) changeColor(ctx context.Context) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.setColor(ctx, c.engine.Next(c.clock.Now()))
 }
 
-func (c *ColorState) changeColor() {
-	var next int
-	var nextDirection = c.direction
-	if c.direction == ASCENDING {
-		next = c.colorIndex + 1
-		if next == len(c.colors)-1 {
-			nextDirection = DESCENDING
-		}
-	} else {
-		next = c.colorIndex - 1
-		if next == 0 {
-			nextDirection = ASCENDING
-		}
+// ObserveBadges learns from the bot's own USERSTATE or GLOBALUSERSTATE badges whether it may use hex colors.
+// Missing badges prove nothing since only one badge is shown, so they never disallow hex colors.
+func (c *ColorState) ObserveBadges(badges map[string]int) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	_, turbo := badges["turbo"]
+	_, prime := badges["premium"]
+	if turbo || prime {
+		c.hexColors = hexColorsAllowed
+	}
+}
+
+// DisallowHexColors makes all following changes use the closest named colors instead of hex colors
+func (c *ColorState) DisallowHexColors() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.disallowHexColors()
+}
+
+func (c *ColorState) disallowHexColors() {
+	if c.hexColors != hexColorsNotAllowed {
+		log.Warn("account doesn't have Turbo or Prime, using the closest named colors from now on")
 	}
-	c.direction = nextDirection
-	c.colorIndex = next
+	c.hexColors = hexColorsNotAllowed
 }
 
-func (c *ColorState) RoutinelyChangeColor(client *twitchirc.Client, selfUsername string, pmq *personalmessagequeue.PersonalMessageQueue) {
+// setColor applies color, trying the fallback if the primary setter fails
+func (c *ColorState) setColor(ctx context.Context, color string) {
+	if c.hexColors == hexColorsNotAllowed {
+		color = NearestNamedColor(color)
+	}
+	err := c.setter.SetColor(ctx, color)
+	if errors.Is(err, ErrHexColorNotAllowed) && c.hexColors != hexColorsNotAllowed {
+		c.disallowHexColors()
+		color = NearestNamedColor(color)
+		err = c.setter.SetColor(ctx, color)
+	}
+	if err == nil {
+		return
+	}
+	if c.fallback == nil {
+		log.WithFields(log.Fields{
+			"color": color,
+		}).Errorf("failed to change color: %s", err)
+		return
+	}
+	fallbackErr := c.fallback.SetColor(ctx, color)
+	if fallbackErr != nil {
+		log.WithFields(log.Fields{
+			"color": color,
+		}).Errorf("failed to change color: %s, fallback failed too: %s", err, fallbackErr)
+		return
+	}
+	log.WithFields(log.Fields{
+		"color": color,
+	}).Warnf("changed color through fallback: %s", err)
+}
+
+// beforeMessageTimeout bounds how long a message waits for the color change before it
+const beforeMessageTimeout = time.Second
+
+// BeforeMessage changes the color if it's configured to change with every message, so each message shows a new color.
+// It gives up after beforeMessageTimeout and skips the change if another one is in flight, rather than hold up the message.
+func (c *ColorState) BeforeMessage(ctx context.Context) {
+	if !c.beforeMessage || !c.lock.TryLock() {
+		return
+	}
+	defer c.lock.Unlock()
+	ctx, cancel := context.WithTimeout(ctx, beforeMessageTimeout)
+	defer cancel()
+	c.setColor(ctx, c.engine.Next(c.clock.Now()))
+}
+
+func (c *ColorState) RoutinelyChangeColor(ctx context.Context) {
+	if c.interval <= 0 {
+		return
+	}
 	for {
-		time.Sleep(10 * time.Second)
-		c.changeColor()
-		client.Say(selfUsername, fmt.Sprintf("/color %s", c.getColor()))
-		pmq.Push(time.Now())
+		if clock.SleepContext(ctx, c.clock, c.interval) != nil {
+			return
+		}
+		c.changeColor(ctx)
 	}
 }
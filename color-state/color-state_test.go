@@ -0,0 +1,76 @@
+package colorstate
+
+import (
+	"context"
+	"harubot/clock"
+	personalmessagequeue "harubot/personal-message-queue"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSetter struct {
+	colors    []string
+	deadlines []time.Duration // left until the deadline of each call
+	lock      sync.Mutex
+}
+
+func (f *fakeSetter) SetColor(ctx context.Context, color string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.colors = append(f.colors, color)
+	if deadline, ok := ctx.Deadline(); ok {
+		f.deadlines = append(f.deadlines, time.Until(deadline))
+	}
+	return nil
+}
+
+func TestColorState_BeforeMessage(t *testing.T) {
+	engine, err := NewEngine(Config{Mode: ModeCycle, Colors: []string{"#ff0000", "#00ff00"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	setter := &fakeSetter{}
+	c := NewColorState(engine, 0, true, setter, nil, clock.NewVirtual(time.Now()))
+
+	c.BeforeMessage(context.Background())
+	c.lock.Lock() // a change in flight
+	c.BeforeMessage(context.Background())
+	c.lock.Unlock()
+
+	if len(setter.colors) != 1 {
+		t.Fatalf("changed color %d times, want once and skipped while another change is in flight", len(setter.colors))
+	}
+	if len(setter.deadlines) != 1 || setter.deadlines[0] > beforeMessageTimeout {
+		t.Errorf("deadlines = %v, want one of at most %s", setter.deadlines, beforeMessageTimeout)
+	}
+}
+
+type fakeSayer struct {
+	said []string
+}
+
+func (f *fakeSayer) Say(channel string, text string) {
+	f.said = append(f.said, channel+": "+text)
+}
+
+func TestIRCColorSetter(t *testing.T) {
+	tests := map[string]string{
+		"blue_violet": "BlueViolet",
+		"golden_rod":  "GoldenRod",
+		"Red":         "Red",
+		"#8a2be2":     "#8a2be2",
+	}
+	for color, want := range tests {
+		sayer := &fakeSayer{}
+		clk := clock.NewVirtual(time.Now())
+		setter := &IRCColorSetter{Client: sayer, SelfUsername: "haruiswaifu", PMQ: personalmessagequeue.NewPersonalMessageQueue(20, clk), Clock: clk}
+		err := setter.SetColor(context.Background(), color)
+		if err != nil {
+			t.Fatalf("SetColor(%q) failed: %s", color, err)
+		}
+		if len(sayer.said) != 1 || sayer.said[0] != "haruiswaifu: /color "+want {
+			t.Errorf("SetColor(%q) said %v, want /color %s", color, sayer.said, want)
+		}
+	}
+}
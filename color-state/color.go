@@ -0,0 +1,129 @@
+package colorstate
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+type rgb struct {
+	r, g, b float64 // 0-255
+}
+
+func parseHex(hex string) (rgb, error) {
+	s := strings.TrimPrefix(hex, "#")
+	if len(s) != 6 {
+		return rgb{}, fmt.Errorf("invalid hex color %q", hex)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return rgb{}, fmt.Errorf("invalid hex color %q", hex)
+	}
+	return rgb{
+		r: float64(v >> 16 & 0xff),
+		g: float64(v >> 8 & 0xff),
+		b: float64(v & 0xff),
+	}, nil
+}
+
+func (c rgb) hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", int(math.Round(c.r)), int(math.Round(c.g)), int(math.Round(c.b)))
+}
+
+type hsv struct {
+	h float64 // 0-360
+	s float64 // 0-1
+	v float64 // 0-1
+}
+
+func (c rgb) hsv() hsv {
+	r, g, b := c.r/255, c.g/255, c.b/255
+	max := math.Max(r, math.Max(g, b))
+	min := math.Min(r, math.Min(g, b))
+	delta := max - min
+
+	h := 0.0
+	switch {
+	case delta == 0:
+		h = 0
+	case max == r:
+		h = 60 * math.Mod((g-b)/delta, 6)
+	case max == g:
+		h = 60 * ((b-r)/delta + 2)
+	default:
+		h = 60 * ((r-g)/delta + 4)
+	}
+	if h < 0 {
+		h += 360
+	}
+	s := 0.0
+	if max > 0 {
+		s = delta / max
+	}
+	return hsv{h: h, s: s, v: max}
+}
+
+func (c hsv) rgb() rgb {
+	chroma := c.v * c.s
+	x := chroma * (1 - math.Abs(math.Mod(c.h/60, 2)-1))
+	m := c.v - chroma
+	var r, g, b float64
+	switch {
+	case c.h < 60:
+		r, g, b = chroma, x, 0
+	case c.h < 120:
+		r, g, b = x, chroma, 0
+	case c.h < 180:
+		r, g, b = 0, chroma, x
+	case c.h < 240:
+		r, g, b = 0, x, chroma
+	case c.h < 300:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	return rgb{r: (r + m) * 255, g: (g + m) * 255, b: (b + m) * 255}
+}
+
+// interpolate returns the color at t (0-1) between from and to, taking the shorter way around the hue circle
+func interpolate(from hsv, to hsv, t float64) hsv {
+	dh := to.h - from.h
+	if dh > 180 {
+		dh -= 360
+	} else if dh < -180 {
+		dh += 360
+	}
+	h := math.Mod(from.h+dh*t+360, 360)
+	return hsv{
+		h: h,
+		s: from.s + (to.s-from.s)*t,
+		v: from.v + (to.v-from.v)*t,
+	}
+}
+
+// gradient expands anchors into a palette with steps colors from each anchor to the next, wrapping back to the first
+func gradient(anchors []string, steps int) ([]string, error) {
+	if steps < 1 {
+		steps = 1
+	}
+	hsvs := []hsv{}
+	for _, anchor := range anchors {
+		c, err := parseHex(anchor)
+		if err != nil {
+			return nil, fmt.Errorf("gradients need hex colors: %s", err)
+		}
+		hsvs = append(hsvs, c.hsv())
+	}
+	if len(hsvs) < 2 {
+		return anchors, nil
+	}
+	palette := []string{}
+	for i, from := range hsvs {
+		to := hsvs[(i+1)%len(hsvs)]
+		for step := 0; step < steps; step++ {
+			palette = append(palette, interpolate(from, to, float64(step)/float64(steps)).rgb().hex())
+		}
+	}
+	return palette, nil
+}
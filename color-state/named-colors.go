@@ -0,0 +1,67 @@
+package colorstate
+
+import (
+	"math"
+	"strings"
+)
+
+// namedColors are the only colors accounts without Turbo or Prime may use, keyed by the name Helix expects
+var namedColors = map[string]string{
+	"blue":         "#0000ff",
+	"blue_violet":  "#8a2be2",
+	"cadet_blue":   "#5f9ea0",
+	"chocolate":    "#d2691e",
+	"coral":        "#ff7f50",
+	"dodger_blue":  "#1e90ff",
+	"firebrick":    "#b22222",
+	"golden_rod":   "#daa520",
+	"green":        "#008000",
+	"hot_pink":     "#ff69b4",
+	"orange_red":   "#ff4500",
+	"red":          "#ff0000",
+	"sea_green":    "#2e8b57",
+	"spring_green": "#00ff7f",
+	"yellow_green": "#9acd32",
+}
+
+// NearestNamedColor maps a hex color to the closest named color, names are returned as they are
+func NearestNamedColor(color string) string {
+	if _, ok := namedColors[strings.ToLower(color)]; ok {
+		return strings.ToLower(color)
+	}
+	c, err := parseHex(color)
+	if err != nil {
+		return color
+	}
+	nearest := ""
+	nearestDistance := math.Inf(1)
+	for name, hex := range namedColors {
+		named, _ := parseHex(hex)
+		d := distance(c, named)
+		if d < nearestDistance || d == nearestDistance && name < nearest {
+			nearest = name
+			nearestDistance = d
+		}
+	}
+	return nearest
+}
+
+// ircColorName turns a named color as Helix expects it, like blue_violet, into the name /color expects, like BlueViolet.
+// Hex colors are returned as they are.
+func ircColorName(color string) string {
+	if _, ok := namedColors[strings.ToLower(color)]; !ok {
+		return color
+	}
+	words := strings.Split(strings.ToLower(color), "_")
+	for i, word := range words {
+		words[i] = strings.ToUpper(word[:1]) + word[1:]
+	}
+	return strings.Join(words, "")
+}
+
+// distance approximates how different two colors look, weighting channels by the mean red like the "redmean" formula
+func distance(a rgb, b rgb) float64 {
+	redMean := (a.r + b.r) / 2
+	dr, dg, db := a.r-b.r, a.g-b.g, a.b-b.b
+	return math.Sqrt((2+redMean/256)*dr*dr + 4*dg*dg + (2+(255-redMean)/256)*db*db)
+}
@@ -0,0 +1,21 @@
+package colorstate
+
+import "testing"
+
+func TestNearestNamedColor(t *testing.T) {
+	tests := map[string]string{
+		"#ff0000":     "red",
+		"#fe0101":     "red",
+		"#ff87f2":     "hot_pink",
+		"#82efff":     "cadet_blue",
+		"#1f8fff":     "dodger_blue",
+		"#3f9ea0":     "cadet_blue",
+		"Blue":        "blue",
+		"not-a-color": "not-a-color",
+	}
+	for color, want := range tests {
+		if got := NearestNamedColor(color); got != want {
+			t.Errorf("NearestNamedColor(%q) = %q, want %q", color, got, want)
+		}
+	}
+}
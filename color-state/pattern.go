@@ -0,0 +1,173 @@
+package colorstate
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"time"
+)
+
+const (
+	ModePingPong = "ping-pong"
+	ModeCycle    = "cycle"
+	ModeRandom   = "random"
+	ModeGradient = "gradient"
+)
+
+// Pattern yields the colors to switch through, one per change
+type Pattern interface {
+	Next() string
+}
+
+type pingPong struct {
+	direction  int
+	colorIndex int
+	colors     []string
+}
+
+func (p *pingPong) Next() string {
+	if len(p.colors) == 1 {
+		return p.colors[0]
+	}
+	if p.direction == ASCENDING {
+		p.colorIndex++
+		if p.colorIndex == len(p.colors)-1 {
+			p.direction = DESCENDING
+		}
+	} else {
+		p.colorIndex--
+		if p.colorIndex == 0 {
+			p.direction = ASCENDING
+		}
+	}
+	return p.colors[p.colorIndex]
+}
+
+type cycle struct {
+	colorIndex int
+	colors     []string
+}
+
+func (c *cycle) Next() string {
+	c.colorIndex = (c.colorIndex + 1) % len(c.colors)
+	return c.colors[c.colorIndex]
+}
+
+type random struct {
+	last   int
+	colors []string
+}
+
+// Next picks any color other than the current one, so every change is visible
+func (r *random) Next() string {
+	if len(r.colors) == 1 {
+		return r.colors[0]
+	}
+	next := rand.Intn(len(r.colors) - 1)
+	if next >= r.last {
+		next++
+	}
+	r.last = next
+	return r.colors[next]
+}
+
+func newPattern(mode string, colors []string, gradientSteps int) (Pattern, error) {
+	if len(colors) == 0 {
+		return nil, fmt.Errorf("no colors given for %s", mode)
+	}
+	switch mode {
+	case ModePingPong, "":
+		return &pingPong{colors: colors}, nil
+	case ModeCycle:
+		return &cycle{colors: colors}, nil
+	case ModeRandom:
+		return &random{colors: colors}, nil
+	case ModeGradient:
+		palette, err := gradient(colors, gradientSteps)
+		if err != nil {
+			return nil, err
+		}
+		return &cycle{colors: palette}, nil
+	default:
+		return nil, fmt.Errorf("unknown color mode %q", mode)
+	}
+}
+
+// ScheduleEntry switches to different colors from a time of day on, until the next entry starts
+type ScheduleEntry struct {
+	From   string   `json:"from"` // local time as 15:04
+	Mode   string   `json:"mode"` // defaults to the general mode
+	Colors []string `json:"colors"`
+}
+
+type Config struct {
+	Mode          string
+	Colors        []string
+	GradientSteps int
+	Schedule      []ScheduleEntry
+}
+
+type scheduledPattern struct {
+	minuteOfDay int
+	pattern     Pattern
+}
+
+// Engine picks the next color, from the scheduled pattern for the current time of day if there is a schedule
+type Engine struct {
+	pattern  Pattern
+	schedule []scheduledPattern // sorted by minuteOfDay
+}
+
+func NewEngine(config Config) (*Engine, error) {
+	e := &Engine{}
+	var err error
+	if len(config.Colors) > 0 || len(config.Schedule) == 0 {
+		e.pattern, err = newPattern(config.Mode, config.Colors, config.GradientSteps)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range config.Schedule {
+		from, err := time.Parse("15:04", entry.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule time %q: %s", entry.From, err)
+		}
+		mode := entry.Mode
+		if mode == "" {
+			mode = config.Mode
+		}
+		pattern, err := newPattern(mode, entry.Colors, config.GradientSteps)
+		if err != nil {
+			return nil, err
+		}
+		e.schedule = append(e.schedule, scheduledPattern{
+			minuteOfDay: from.Hour()*60 + from.Minute(),
+			pattern:     pattern,
+		})
+	}
+	sort.Slice(e.schedule, func(i, j int) bool {
+		return e.schedule[i].minuteOfDay < e.schedule[j].minuteOfDay
+	})
+	return e, nil
+}
+
+// Next returns the color to change to at now
+func (e *Engine) Next(now time.Time) string {
+	return e.patternAt(now).Next()
+}
+
+func (e *Engine) patternAt(now time.Time) Pattern {
+	if len(e.schedule) == 0 {
+		return e.pattern
+	}
+	minute := now.Hour()*60 + now.Minute()
+	// before the first entry of the day, the last entry of the previous day is still active
+	active := e.schedule[len(e.schedule)-1].pattern
+	for _, entry := range e.schedule {
+		if entry.minuteOfDay > minute {
+			break
+		}
+		active = entry.pattern
+	}
+	return active
+}
@@ -0,0 +1,111 @@
+package colorstate
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func nextColors(p Pattern, n int) []string {
+	colors := []string{}
+	for i := 0; i < n; i++ {
+		colors = append(colors, p.Next())
+	}
+	return colors
+}
+
+func TestPatterns(t *testing.T) {
+	tests := []struct {
+		name   string
+		mode   string
+		colors []string
+		steps  int
+		want   []string
+	}{
+		{
+			name:   "ping-pong",
+			mode:   ModePingPong,
+			colors: []string{"a", "b", "c"},
+			want:   []string{"b", "c", "b", "a", "b", "c"},
+		},
+		{
+			name:   "ping-pong with a single color",
+			mode:   ModePingPong,
+			colors: []string{"a"},
+			want:   []string{"a", "a", "a"},
+		},
+		{
+			name:   "cycle",
+			mode:   ModeCycle,
+			colors: []string{"a", "b", "c"},
+			want:   []string{"b", "c", "a", "b"},
+		},
+		{
+			name:   "gradient",
+			mode:   ModeGradient,
+			colors: []string{"#ff0000", "#0000ff"},
+			steps:  2,
+			want:   []string{"#ff00ff", "#0000ff", "#ff00ff", "#ff0000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPattern(tt.mode, tt.colors, tt.steps)
+			if err != nil {
+				t.Fatalf("newPattern() error = %v", err)
+			}
+			if got := nextColors(p, len(tt.want)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRandomNeverRepeats(t *testing.T) {
+	p, _ := newPattern(ModeRandom, []string{"a", "b"}, 0)
+	previous := ""
+	for _, color := range nextColors(p, 20) {
+		if color == previous {
+			t.Fatalf("random pattern repeated %q", color)
+		}
+		previous = color
+	}
+}
+
+func TestEngineSchedule(t *testing.T) {
+	e, err := NewEngine(Config{
+		Mode: ModeCycle,
+		Schedule: []ScheduleEntry{
+			{From: "20:00", Colors: []string{"night"}},
+			{From: "08:00", Colors: []string{"day"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]string{
+		"07:59": "night",
+		"08:00": "day",
+		"19:30": "day",
+		"23:00": "night",
+	}
+	for clockTime, want := range tests {
+		now, _ := time.Parse("15:04", clockTime)
+		if got := e.Next(now); got != want {
+			t.Errorf("Next(%s) = %q, want %q", clockTime, got, want)
+		}
+	}
+}
+
+func TestNewEngineRejectsInvalidConfig(t *testing.T) {
+	configs := []Config{
+		{Mode: "sparkle", Colors: []string{"#ffffff"}},
+		{Mode: ModeGradient, Colors: []string{"red", "blue"}},
+		{Schedule: []ScheduleEntry{{From: "noon", Colors: []string{"#ffffff"}}}},
+	}
+	for _, config := range configs {
+		if _, err := NewEngine(config); err == nil {
+			t.Errorf("NewEngine(%+v) succeeded, want error", config)
+		}
+	}
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	log "github.com/sirupsen/logrus"
+	"harubot/commands"
+	decisionlog "harubot/decision-log"
+	messagequeue "harubot/message-queue"
+	"harubot/pause"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultCommandPrefix = "!hb"
+
+func (state *state) registerCommands(prefix string) {
+	if prefix == "" {
+		prefix = defaultCommandPrefix
+	}
+	r := commands.NewRegistry(prefix)
+	r.Register(commands.Command{
+		Name:        "pause",
+		Usage:       "[channel|all] [duration]",
+		Description: "stops echoing, autoreplying and pyramids, until resumed or for a duration like 30m",
+		Level:       commands.LevelModerator,
+		MaxArgs:     2,
+		Run: func(inv *commands.Invocation) (string, error) {
+			return state.pauseCommand(inv, true)
+		},
+	})
+	r.Register(commands.Command{
+		Name:        "resume",
+		Usage:       "[channel|all]",
+		Description: "undoes pause",
+		Level:       commands.LevelModerator,
+		MaxArgs:     1,
+		Run: func(inv *commands.Invocation) (string, error) {
+			return state.pauseCommand(inv, false)
+		},
+	})
+	r.Register(commands.Command{
+		Name:        "threshold",
+		Usage:       "<channel> [word-count value]",
+		Description: "shows or changes how many users have to spam a sentence before it's echoed",
+		Level:       commands.LevelOwner,
+		MinArgs:     1,
+		MaxArgs:     3,
+		Run:         state.thresholdCommand,
+	})
+	r.Register(commands.Command{
+		Name:        "status",
+		Usage:       "[channel]",
+		Description: "shows velocities and whether the bot is paused",
+		Level:       commands.LevelModerator,
+		MaxArgs:     1,
+		Run:         state.statusCommand,
+	})
+	r.Register(commands.Command{
+		Name:    "join",
+		Usage:   "<channel>",
+		Level:   commands.LevelOwner,
+		MinArgs: 1,
+		MaxArgs: 1,
+		Run: func(inv *commands.Invocation) (string, error) {
+			channel := strings.ToLower(strings.TrimPrefix(inv.Args[0], "#"))
+			if !state.addChannel(channel) {
+				return "", fmt.Errorf("already in #%s", channel)
+			}
+			state.client.Join(channel)
+			return fmt.Sprintf("joined #%s", channel), nil
+		},
+	})
+	r.Register(commands.Command{
+		Name:    "part",
+		Usage:   "[channel]",
+		Level:   commands.LevelOwner,
+		MaxArgs: 1,
+		Run: func(inv *commands.Invocation) (string, error) {
+			channel, err := targetChannel(inv)
+			if err != nil {
+				return "", err
+			}
+			if !state.removeChannel(channel) {
+				return "", fmt.Errorf("not in #%s", channel)
+			}
+			state.client.Depart(channel)
+			return fmt.Sprintf("left #%s", channel), nil
+		},
+	})
+	state.commands = r
+}
+
+// targetChannel is the channel given as the first argument, or the one the command was sent in
+func targetChannel(inv *commands.Invocation) (string, error) {
+	if len(inv.Args) > 0 {
+		return strings.ToLower(strings.TrimPrefix(inv.Args[0], "#")), nil
+	}
+	if inv.Whispered() {
+		return "", commands.ErrUsage
+	}
+	return inv.Channel, nil
+}
+
+// commandLevel decides what user may control, moderators only in the channel they moderate
+func (state *state) commandLevel(user string, badges map[string]int) commands.Level {
+	user = strings.ToLower(user)
+	switch {
+	case user == state.selfUsername:
+		return commands.LevelSelf
+	case state.owners[user]:
+		return commands.LevelOwner
+	case badges["moderator"] == 1 || badges["broadcaster"] == 1:
+		return commands.LevelModerator
+	default:
+		return commands.LevelEveryone
+	}
+}
+
+// onCommand runs m if it's a command and reports whether it was one
+func (state *state) onCommand(m twitchirc.PrivateMessage) bool {
+	reply, handled := state.commands.Dispatch(m.Message, commands.Invocation{
+		Channel: m.Channel,
+		User:    m.User.Name,
+		Level:   state.commandLevel(m.User.Name, m.User.Badges),
+	})
+	if !handled {
+		return false
+	}
+	log.WithFields(log.Fields{
+		"channel": m.Channel,
+		"user":    m.User.Name,
+		"command": m.Message,
+		"reply":   reply,
+	}).Info("ran command")
+	state.decide(m.Channel, decisionlog.ActionCommand, m.Message, fmt.Sprintf("sent by %s", m.User.Name))
+	if reply != "" {
+		state.say(m.Channel, fmt.Sprintf("@%s, %s", m.User.DisplayName, reply))
+	}
+	return true
+}
+
+func (state *state) onWhisperMessage(m twitchirc.WhisperMessage) {
+	level := state.commandLevel(m.User.Name, nil)
+	if level == commands.LevelEveryone {
+		return // whispers are only for owners, there's no channel to moderate
+	}
+	reply, handled := state.commands.Dispatch(m.Message, commands.Invocation{
+		User:  m.User.Name,
+		Level: level,
+	})
+	if !handled {
+		return
+	}
+	log.WithFields(log.Fields{
+		"user":    m.User.Name,
+		"command": m.Message,
+		"reply":   reply,
+	}).Info("ran whispered command")
+	state.decide("", decisionlog.ActionCommand, m.Message, fmt.Sprintf("whispered by %s", m.User.Name))
+	if reply != "" {
+		state.client.Whisper(m.User.Name, reply)
+	}
+}
+
+// pauseCommand pauses or resumes the channel it was sent in, the given channel or, with "all", every channel.
+// Pauses last until resumed unless a duration like 30m is given.
+func (state *state) pauseCommand(inv *commands.Invocation, paused bool) (string, error) {
+	var d time.Duration
+	args := []string{}
+	for _, arg := range inv.Args {
+		parsed, err := time.ParseDuration(arg)
+		if err == nil && paused && d == 0 && parsed > 0 {
+			d = parsed
+			continue
+		}
+		args = append(args, arg)
+	}
+	if len(args) > 1 {
+		return "", commands.ErrUsage
+	}
+	inv.Args = args
+
+	channel := pause.Global
+	if len(args) == 1 && strings.ToLower(args[0]) == "all" || len(args) == 0 && inv.Whispered() {
+		if inv.Level < commands.LevelOwner {
+			return "", errors.New("only owners may pause all channels")
+		}
+	} else {
+		var err error
+		channel, err = targetChannel(inv)
+		if err != nil {
+			return "", err
+		}
+		if inv.Level < commands.LevelOwner && channel != inv.Channel {
+			return "", errors.New("moderators may only pause the channel they moderate")
+		}
+	}
+
+	where := "in all channels"
+	if channel != pause.Global {
+		where = fmt.Sprintf("in #%s", channel)
+	}
+	reason := fmt.Sprintf("by %s", inv.User)
+	if !paused {
+		state.resume(channel, reason)
+		return fmt.Sprintf("resumed %s", where), nil
+	}
+	effective := state.pause(channel, d, reason)
+	if effective.Until.IsZero() {
+		if d > 0 {
+			return fmt.Sprintf("already paused %s until resumed", where), nil
+		}
+		return fmt.Sprintf("paused %s", where), nil
+	}
+	remaining := effective.Until.Sub(state.clock.Now()).Round(time.Second)
+	if remaining > d {
+		return fmt.Sprintf("already paused %s for %s", where, remaining), nil
+	}
+	return fmt.Sprintf("paused %s for %s", where, d), nil
+}
+
+func (state *state) thresholdCommand(inv *commands.Invocation) (string, error) {
+	channel := strings.ToLower(strings.TrimPrefix(inv.Args[0], "#"))
+	switch len(inv.Args) {
+	case 1:
+	case 3:
+		wordCount, err := strconv.Atoi(inv.Args[1])
+		if err != nil {
+			return "", commands.ErrUsage
+		}
+		value, err := strconv.ParseFloat(inv.Args[2], 32)
+		if err != nil {
+			return "", commands.ErrUsage
+		}
+		err = messagequeue.SetThreshold(channel, wordCount, float32(value))
+		if err != nil {
+			return "", err
+		}
+	default:
+		return "", commands.ErrUsage
+	}
+	threshs := messagequeue.Thresholds(channel)
+	return fmt.Sprintf("thresholds in #%s: %v users for 1, %v for 2, %v for 3+ words", channel, threshs[0], threshs[1], threshs[2]), nil
+}
+
+func (state *state) statusCommand(inv *commands.Invocation) (string, error) {
+	status := fmt.Sprintf("in %d channels, sending %.2f messages/second", len(state.channels()), state.personalMessageQueue.Velocity())
+	if len(inv.Args) == 0 && inv.Whispered() {
+		if state.isPaused(pause.Global) {
+			status += ", paused everywhere"
+		}
+		return status, nil
+	}
+	channel, err := targetChannel(inv)
+	if err != nil {
+		return "", err
+	}
+	mq, joined := state.messageQueue(channel)
+	if !joined {
+		return "", fmt.Errorf("not in #%s", channel)
+	}
+	mq.Lock()
+	velocity := mq.Velocity()
+	mq.Unlock()
+	status += fmt.Sprintf(", #%s at %.2f messages/second", channel, velocity)
+	if state.isPaused(channel) {
+		status += ", paused"
+	}
+	return status, nil
+}
@@ -0,0 +1,152 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Level is how much a user is trusted to control the bot, higher levels may run everything lower levels may
+type Level int
+
+const (
+	LevelEveryone Level = iota
+	LevelModerator
+	LevelOwner
+	LevelSelf
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelModerator:
+		return "moderator"
+	case LevelOwner:
+		return "owner"
+	case LevelSelf:
+		return "self"
+	default:
+		return "everyone"
+	}
+}
+
+// ErrUsage is returned by a command's Run if its arguments are valid in number but not in content
+var ErrUsage = errors.New("invalid arguments")
+
+// Invocation is a single use of a command
+type Invocation struct {
+	Channel string // empty for whispers
+	User    string
+	Level   Level
+	Args    []string
+}
+
+// Whispered tells whether the command was sent as a whisper instead of in a channel
+func (inv *Invocation) Whispered() bool {
+	return inv.Channel == ""
+}
+
+type Command struct {
+	Name        string
+	Usage       string // arguments, as shown in help and error replies
+	Description string
+	Level       Level
+	MinArgs     int
+	MaxArgs     int // -1 for no limit
+	Run         func(inv *Invocation) (string, error)
+}
+
+// Registry parses messages starting with a prefix and runs the command they name, if the user may
+type Registry struct {
+	prefix   string
+	commands map[string]*Command
+}
+
+func NewRegistry(prefix string) *Registry {
+	r := &Registry{
+		prefix:   prefix,
+		commands: map[string]*Command{},
+	}
+	r.Register(Command{
+		Name:        "help",
+		Usage:       "[command]",
+		Description: "lists commands or explains one",
+		Level:       LevelModerator,
+		MaxArgs:     1,
+		Run:         r.help,
+	})
+	return r
+}
+
+func (r *Registry) Register(c Command) {
+	r.commands[strings.ToLower(c.Name)] = &c
+}
+
+// Parse splits message into a command name and its arguments, if it starts with the prefix
+func (r *Registry) Parse(message string) (string, []string, bool) {
+	words := strings.Fields(message)
+	if len(words) == 0 || words[0] != r.prefix {
+		return "", nil, false
+	}
+	if len(words) == 1 {
+		return "help", []string{}, true
+	}
+	return strings.ToLower(words[1]), words[2:], true
+}
+
+// Dispatch runs the command in message and returns the reply to send back.
+// handled is false if message isn't a command at all or was sent by someone who can't run commands,
+// so it can be treated as a normal chat message.
+func (r *Registry) Dispatch(message string, inv Invocation) (reply string, handled bool) {
+	name, args, ok := r.Parse(message)
+	if !ok {
+		return "", false
+	}
+	if inv.Level == LevelEveryone {
+		return "", false // don't answer to everyone who tries, to them it's just chat
+	}
+	c, found := r.commands[name]
+	if !found {
+		return fmt.Sprintf("unknown command %s, try %s help", name, r.prefix), true
+	}
+	if inv.Level < c.Level {
+		return fmt.Sprintf("%s is for %s only", name, c.Level), true
+	}
+	if len(args) < c.MinArgs || c.MaxArgs >= 0 && len(args) > c.MaxArgs {
+		return r.usage(c), true
+	}
+	inv.Args = args
+	reply, err := c.Run(&inv)
+	if errors.Is(err, ErrUsage) {
+		return r.usage(c), true
+	}
+	if err != nil {
+		return fmt.Sprintf("%s failed: %s", name, err), true
+	}
+	return reply, true
+}
+
+func (r *Registry) usage(c *Command) string {
+	return strings.TrimSpace(fmt.Sprintf("usage: %s %s %s", r.prefix, c.Name, c.Usage))
+}
+
+func (r *Registry) help(inv *Invocation) (string, error) {
+	if len(inv.Args) == 1 {
+		c, found := r.commands[strings.ToLower(inv.Args[0])]
+		if !found {
+			return "", fmt.Errorf("unknown command %s", inv.Args[0])
+		}
+		if c.Description == "" {
+			return r.usage(c), nil
+		}
+		return fmt.Sprintf("%s - %s", r.usage(c), c.Description), nil
+	}
+	names := []string{}
+	for name, c := range r.commands {
+		if inv.Level >= c.Level {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return fmt.Sprintf("commands: %s", strings.Join(names, ", ")), nil
+}
@@ -0,0 +1,63 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Dispatch(t *testing.T) {
+	r := NewRegistry("!hb")
+	r.Register(Command{
+		Name:    "echo",
+		Usage:   "<word> [word]",
+		Level:   LevelModerator,
+		MinArgs: 1,
+		MaxArgs: 2,
+		Run: func(inv *Invocation) (string, error) {
+			return strings.Join(inv.Args, " "), nil
+		},
+	})
+	r.Register(Command{
+		Name:    "number",
+		Usage:   "<n>",
+		Level:   LevelOwner,
+		MinArgs: 1,
+		MaxArgs: 1,
+		Run: func(inv *Invocation) (string, error) {
+			if inv.Args[0] != "1" {
+				return "", fmt.Errorf("%w: not one", ErrUsage)
+			}
+			return "one", nil
+		},
+	})
+
+	tests := []struct {
+		message string
+		level   Level
+		reply   string
+		handled bool
+	}{
+		{"hello !hb echo", LevelSelf, "", false},
+		{"!hbecho a", LevelSelf, "", false},
+		{"!hb echo a b", LevelModerator, "a b", true},
+		{"!hb ECHO a", LevelSelf, "a", true},
+		{"!hb echo a", LevelEveryone, "", false},
+		{"!hb echo", LevelModerator, "usage: !hb echo <word> [word]", true},
+		{"!hb echo a b c", LevelModerator, "usage: !hb echo <word> [word]", true},
+		{"!hb number 1", LevelModerator, "number is for owner only", true},
+		{"!hb number 2", LevelOwner, "usage: !hb number <n>", true},
+		{"!hb number 1", LevelOwner, "one", true},
+		{"!hb nope", LevelOwner, "unknown command nope, try !hb help", true},
+		{"!hb", LevelModerator, "commands: echo, help", true},
+		{"!hb help number", LevelModerator, "usage: !hb number <n>", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.message, func(t *testing.T) {
+			reply, handled := r.Dispatch(tt.message, Invocation{User: "someone", Level: tt.level})
+			if reply != tt.reply || handled != tt.handled {
+				t.Errorf("Dispatch() = %q, %v, want %q, %v", reply, handled, tt.reply, tt.handled)
+			}
+		})
+	}
+}
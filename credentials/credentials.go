@@ -0,0 +1,75 @@
+package credentials
+
+import (
+	"fmt"
+	"sync"
+)
+
+type Secrets struct {
+	Username           string `json:"username"`
+	OauthKey           string `json:"oauth-key"`
+	TwitchAccessToken  string `json:"twitch-access-token"`
+	TwitchClientID     string `json:"twitch-client-id"`
+	TwitchClientSecret string `json:"twitch-client-secret"`
+	TwitchRefreshToken string `json:"twitch-refresh-token"`
+}
+
+// IRCToken returns the token to log into chat with.
+// Without a separate oauth-key, chat uses the user access token and therefore follows its rotations.
+func (s Secrets) IRCToken() string {
+	if s.OauthKey != "" {
+		return s.OauthKey
+	}
+	return fmt.Sprintf("oauth:%s", s.TwitchAccessToken)
+}
+
+// Provider holds the current secrets in memory and notifies subscribers whenever tokens are rotated
+type Provider struct {
+	secrets     Secrets
+	store       Store
+	subscribers []func(Secrets)
+	lock        sync.RWMutex
+}
+
+// NewProvider creates a provider for s, persisting rotations to store unless it's nil
+func NewProvider(s Secrets, store Store) *Provider {
+	return &Provider{
+		secrets: s,
+		store:   store,
+	}
+}
+
+func (p *Provider) Get() Secrets {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.secrets
+}
+
+// Subscribe registers onRotate to be called with the new secrets after every rotation
+func (p *Provider) Subscribe(onRotate func(Secrets)) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.subscribers = append(p.subscribers, onRotate)
+}
+
+// Rotate replaces the user access token and, if Twitch handed out a new one, the refresh token.
+// The new secrets are persisted before subscribers are notified; a failure to persist is returned but doesn't stop the rotation.
+func (p *Provider) Rotate(accessToken string, refreshToken string) error {
+	p.lock.Lock()
+	p.secrets.TwitchAccessToken = accessToken
+	if refreshToken != "" {
+		p.secrets.TwitchRefreshToken = refreshToken
+	}
+	s := p.secrets
+	subscribers := append([]func(Secrets){}, p.subscribers...)
+	var err error
+	if p.store != nil {
+		err = p.store.Save(s)
+	}
+	p.lock.Unlock()
+
+	for _, onRotate := range subscribers {
+		onRotate(s)
+	}
+	return err
+}
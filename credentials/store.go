@@ -0,0 +1,179 @@
+package credentials
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	atomicfile "harubot/atomic-file"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Store is where secrets are kept at rest
+type Store interface {
+	Load() (Secrets, error)
+	Save(s Secrets) error
+}
+
+// FileStore keeps secrets as plain JSON, e.g. in a file mounted into the container
+type FileStore struct {
+	Path string
+}
+
+func (f FileStore) Load() (Secrets, error) {
+	s := Secrets{}
+	bytes, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return s, err
+	}
+	err = json.Unmarshal(bytes, &s)
+	return s, err
+}
+
+func (f FileStore) Save(s Secrets) error {
+	bytes, err := json.Marshal(&s)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(f.Path, bytes, 0600)
+}
+
+const encryptedFileHeader = "harubot-encrypted-secrets-v1\n"
+
+// EncryptedFileStore keeps secrets encrypted with AES-256-GCM
+type EncryptedFileStore struct {
+	Path string
+	Key  []byte // 32 bytes
+}
+
+// NewEncryptedFileStore creates a store at path using a base64 encoded 32 byte key
+func NewEncryptedFileStore(path string, encodedKey string) (*EncryptedFileStore, error) {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encodedKey))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode encryption key: %s", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("encryption key must be 32 bytes, got %d", len(key))
+	}
+	return &EncryptedFileStore{
+		Path: path,
+		Key:  key,
+	}, nil
+}
+
+func (e *EncryptedFileStore) Load() (Secrets, error) {
+	s := Secrets{}
+	bytes, err := ioutil.ReadFile(e.Path)
+	if err != nil {
+		return s, err
+	}
+	encoded := strings.TrimPrefix(string(bytes), encryptedFileHeader)
+	if len(encoded) == len(bytes) {
+		return s, errors.New("secrets file isn't encrypted")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return s, err
+	}
+	aead, err := e.aead()
+	if err != nil {
+		return s, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return s, errors.New("encrypted secrets are truncated")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return s, fmt.Errorf("failed to decrypt secrets, is the key correct? %s", err)
+	}
+	err = json.Unmarshal(plaintext, &s)
+	return s, err
+}
+
+func (e *EncryptedFileStore) Save(s Secrets) error {
+	plaintext, err := json.Marshal(&s)
+	if err != nil {
+		return err
+	}
+	aead, err := e.aead()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	contents := encryptedFileHeader + base64.StdEncoding.EncodeToString(sealed) + "\n"
+	return atomicfile.WriteFile(e.Path, []byte(contents), 0600)
+}
+
+func (e *EncryptedFileStore) aead() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(e.Key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// environmentVariables maps the variables secrets can be passed in through to their fields
+var environmentVariables = map[string]func(s *Secrets) *string{
+	"HARUBOT_USERNAME":             func(s *Secrets) *string { return &s.Username },
+	"HARUBOT_OAUTH_KEY":            func(s *Secrets) *string { return &s.OauthKey },
+	"HARUBOT_TWITCH_ACCESS_TOKEN":  func(s *Secrets) *string { return &s.TwitchAccessToken },
+	"HARUBOT_TWITCH_CLIENT_ID":     func(s *Secrets) *string { return &s.TwitchClientID },
+	"HARUBOT_TWITCH_CLIENT_SECRET": func(s *Secrets) *string { return &s.TwitchClientSecret },
+	"HARUBOT_TWITCH_REFRESH_TOKEN": func(s *Secrets) *string { return &s.TwitchRefreshToken },
+}
+
+// fillFromEnvironment fills in fields that are still empty from environment variables.
+// Stored values win, since tokens rotated at runtime are only ever written to the store.
+func fillFromEnvironment(s *Secrets, lookup func(string) (string, bool)) {
+	for variable, field := range environmentVariables {
+		value, ok := lookup(variable)
+		if ok && *field(s) == "" {
+			*field(s) = value
+		}
+	}
+}
+
+// EncryptionKeyVariable holds the base64 encoded key for encrypted secrets files
+const EncryptionKeyVariable = "HARUBOT_SECRETS_KEY"
+
+// OpenStore returns the store for path, encrypted if the encryption key variable is set, or nil for an empty path
+func OpenStore(path string) (Store, error) {
+	if path == "" {
+		return nil, nil
+	}
+	key, ok := os.LookupEnv(EncryptionKeyVariable)
+	if ok && key != "" {
+		return NewEncryptedFileStore(path, key)
+	}
+	return FileStore{Path: path}, nil
+}
+
+// Open loads secrets from the store at path, filling in the rest from environment variables.
+// A missing file counts as empty and is created once tokens rotate, so e.g. a container can start from environment variables alone.
+// With an empty path, secrets only come from the environment and rotated tokens are only kept in memory.
+func Open(path string) (*Provider, error) {
+	store, err := OpenStore(path)
+	if err != nil {
+		return nil, err
+	}
+	s := Secrets{}
+	if store != nil {
+		s, err = store.Load()
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	fillFromEnvironment(&s, os.LookupEnv)
+	return NewProvider(s, store), nil
+}
@@ -0,0 +1,120 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testSecrets = Secrets{
+	Username:           "haruiswaifu",
+	TwitchAccessToken:  "access-token",
+	TwitchClientID:     "client-id",
+	TwitchClientSecret: "client-secret",
+	TwitchRefreshToken: "refresh-token",
+}
+
+func TestStores(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	encryptedPath := filepath.Join(t.TempDir(), "secrets.enc")
+	encrypted, err := NewEncryptedFileStore(encryptedPath, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainPath := filepath.Join(t.TempDir(), "secrets.json")
+	tests := []struct {
+		name  string
+		path  string
+		store Store
+	}{
+		{
+			name:  "plain file",
+			path:  plainPath,
+			store: FileStore{Path: plainPath},
+		},
+		{
+			name:  "encrypted file",
+			path:  encryptedPath,
+			store: encrypted,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.store.Save(testSecrets)
+			if err != nil {
+				t.Fatalf("Save() error = %v", err)
+			}
+			info, err := os.Stat(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != 0600 {
+				t.Errorf("file mode = %o, want 600", info.Mode().Perm())
+			}
+			got, err := tt.store.Load()
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if got != testSecrets {
+				t.Errorf("Load() = %+v, want %+v", got, testSecrets)
+			}
+			leftovers, _ := filepath.Glob(filepath.Join(filepath.Dir(tt.path), ".*.tmp-*"))
+			if len(leftovers) != 0 {
+				t.Errorf("temporary files were left behind: %v", leftovers)
+			}
+		})
+	}
+
+	contents, _ := ioutil.ReadFile(encryptedPath)
+	if strings.Contains(string(contents), "client-secret") {
+		t.Errorf("encrypted file contains secrets in plain text")
+	}
+	wrongKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 32)))
+	wrong, _ := NewEncryptedFileStore(encryptedPath, wrongKey)
+	if _, err := wrong.Load(); err == nil {
+		t.Errorf("Load() with the wrong key succeeded")
+	}
+}
+
+func TestFillFromEnvironment(t *testing.T) {
+	env := map[string]string{
+		"HARUBOT_USERNAME":             "from-env",
+		"HARUBOT_TWITCH_CLIENT_SECRET": "secret-from-env",
+	}
+	s := Secrets{Username: "stored"}
+	fillFromEnvironment(&s, func(variable string) (string, bool) {
+		value, ok := env[variable]
+		return value, ok
+	})
+	if s.Username != "stored" {
+		t.Errorf("Username = %q, stored values should win over the environment", s.Username)
+	}
+	if s.TwitchClientSecret != "secret-from-env" {
+		t.Errorf("TwitchClientSecret = %q, want %q", s.TwitchClientSecret, "secret-from-env")
+	}
+}
+
+func TestOpen_MissingFile(t *testing.T) {
+	t.Setenv(EncryptionKeyVariable, "")
+	t.Setenv("HARUBOT_USERNAME", "from-env")
+	path := filepath.Join(t.TempDir(), "secrets.json")
+	provider, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open of a missing file failed: %s", err)
+	}
+	if got := provider.Get().Username; got != "from-env" {
+		t.Errorf("Username = %q, want %q", got, "from-env")
+	}
+	err = provider.Rotate("new-access-token", "new-refresh-token")
+	if err != nil {
+		t.Fatalf("Rotate failed: %s", err)
+	}
+	stored, err := FileStore{Path: path}.Load()
+	if err != nil || stored.TwitchAccessToken != "new-access-token" {
+		t.Errorf("rotated tokens weren't written to the missing file: %+v, %v", stored, err)
+	}
+}
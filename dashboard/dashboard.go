@@ -0,0 +1,231 @@
+package dashboard
+
+import (
+	"context"
+	"fmt"
+	"harubot/admin"
+	decisionlog "harubot/decision-log"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	clearScreen     = "\x1b[H\x1b[2J"
+	enterAltScreen  = "\x1b[?1049h\x1b[?25l"
+	leaveAltScreen  = "\x1b[?25h\x1b[?1049l"
+	bold            = "\x1b[1m"
+	dim             = "\x1b[2m"
+	green           = "\x1b[32m"
+	yellow          = "\x1b[33m"
+	red             = "\x1b[31m"
+	reset           = "\x1b[0m"
+	sparklineLength = 30
+	nameWidth       = 16
+	maxOutboundRows = 5
+)
+
+var sparks = []rune("▁▂▃▄▅▆▇█")
+
+// Dashboard draws the state of a running bot, polled from its admin API, onto a terminal
+type Dashboard struct {
+	client     *admin.Client
+	out        io.Writer
+	width      int
+	height     int
+	velocities map[string][]float64 // the latest samples per channel, oldest first
+}
+
+func New(client *admin.Client, out io.Writer, width int, height int) *Dashboard {
+	return &Dashboard{
+		client:     client,
+		out:        out,
+		width:      width,
+		height:     height,
+		velocities: map[string][]float64{},
+	}
+}
+
+// Run redraws the dashboard every interval until ctx is cancelled
+func (d *Dashboard) Run(ctx context.Context, interval time.Duration) error {
+	fmt.Fprint(d.out, enterAltScreen)
+	defer fmt.Fprint(d.out, leaveAltScreen)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		fmt.Fprint(d.out, clearScreen+d.poll(ctx))
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (d *Dashboard) poll(ctx context.Context) string {
+	status, err := d.client.Status(ctx)
+	if err != nil {
+		return d.fit([]string{fmt.Sprintf("%sfailed to reach the bot: %s%s", red, err, reset)})
+	}
+	decisions, err := d.client.Decisions(ctx, d.height)
+	if err != nil {
+		return d.fit([]string{fmt.Sprintf("%sfailed to get decisions: %s%s", red, err, reset)})
+	}
+	d.sample(status)
+	return d.Render(status, decisions)
+}
+
+// sample remembers the current velocity of every channel for the sparklines
+func (d *Dashboard) sample(status *admin.Status) {
+	joined := map[string]bool{}
+	for _, c := range status.Channels {
+		joined[c.Name] = true
+		samples := append(d.velocities[c.Name], c.Velocity)
+		if len(samples) > sparklineLength {
+			samples = samples[len(samples)-sparklineLength:]
+		}
+		d.velocities[c.Name] = samples
+	}
+	for channel := range d.velocities {
+		if !joined[channel] {
+			delete(d.velocities, channel)
+		}
+	}
+}
+
+// Render lays out status and decisions to fill the terminal, busiest channels first
+func (d *Dashboard) Render(status *admin.Status, decisions []decisionlog.Decision) string {
+	header := fmt.Sprintf("%sharubot%s  %s  sending %.2f/%.2f messages/second", bold, reset, status.Time.Format("15:04:05"), status.PersonalVelocity, status.MaxPersonalVelocity)
+	if status.PausedGlobally {
+		header += fmt.Sprintf("  %sPAUSED%s", yellow, reset)
+	}
+
+	// outbound messages get at most a third of the terminal, so the channels stay visible while a lot is queued
+	outboundRows := maxOutboundRows
+	if outboundRows > d.height/3 {
+		outboundRows = d.height / 3
+	}
+	outbound := []string{fmt.Sprintf("%soutbound%s", bold, reset)}
+	for i, o := range status.Outbound {
+		if i == outboundRows {
+			outbound = append(outbound, fmt.Sprintf("%s…and %d more%s", dim, len(status.Outbound)-outboundRows, reset))
+			break
+		}
+		outbound = append(outbound, truncate(fmt.Sprintf("#%s %s in %s", o.Channel, o.Description, o.Due.Sub(status.Time).Round(time.Second)), d.width))
+	}
+
+	// the rest is split evenly between channels and decisions
+	remaining := d.height - 4 - len(outbound)
+	if remaining < 0 {
+		remaining = 0 // too short a terminal, fit cuts off whatever doesn't fit
+	}
+	channelRows := remaining / 2
+	decisionRows := remaining - channelRows
+
+	channels := append([]admin.Channel{}, status.Channels...)
+	sort.SliceStable(channels, func(i, j int) bool {
+		return channels[i].Velocity > channels[j].Velocity
+	})
+	channelLines := []string{fmt.Sprintf("%s%-*s %6s %-*s %s%s", bold, nameWidth, "channel", "msg/s", sparklineLength, "velocity", "closest to being echoed", reset)}
+	for i, c := range channels {
+		if i == channelRows {
+			break
+		}
+		channelLines = append(channelLines, d.channelLine(c))
+	}
+
+	decisionLines := []string{fmt.Sprintf("%sdecisions%s", bold, reset)}
+	if len(decisions) > decisionRows {
+		decisions = decisions[len(decisions)-decisionRows:]
+	}
+	for _, dec := range decisions {
+		line := fmt.Sprintf("%s %s", dec.Time.Format("15:04:05"), dec.Action)
+		if dec.Channel != "" {
+			line += fmt.Sprintf(" #%s", dec.Channel)
+		}
+		if dec.Message != "" {
+			line += fmt.Sprintf(" %q", dec.Message)
+		}
+		line += fmt.Sprintf(" - %s", dec.Reason)
+		decisionLines = append(decisionLines, truncate(line, d.width))
+	}
+
+	lines := []string{header, ""}
+	lines = append(lines, channelLines...)
+	lines = append(lines, "")
+	lines = append(lines, outbound...)
+	lines = append(lines, "")
+	lines = append(lines, decisionLines...)
+	return d.fit(lines)
+}
+
+func (d *Dashboard) channelLine(c admin.Channel) string {
+	name := truncate(c.Name, nameWidth)
+	padding := strings.Repeat(" ", nameWidth-utf8.RuneCountInString(name))
+	if c.Paused {
+		name = yellow + name + reset
+	}
+	line := fmt.Sprintf("%s%s %6.2f %s ", name, padding, c.Velocity, sparkline(d.velocities[c.Name], sparklineLength))
+
+	space := d.width - nameWidth - 6 - sparklineLength - 3
+	candidates := []string{}
+	for _, candidate := range c.Candidates {
+		text := fmt.Sprintf("%s %d/%v", candidate.Text, candidate.Count, candidate.Threshold)
+		if utf8.RuneCountInString(text) > space {
+			break
+		}
+		space -= utf8.RuneCountInString(text) + 2
+		if float32(candidate.Count) >= candidate.Threshold {
+			text = green + text + reset
+		}
+		candidates = append(candidates, text)
+	}
+	return line + strings.Join(candidates, ", ")
+}
+
+// fit pads lines to the height of the terminal, or cuts them off at it
+func (d *Dashboard) fit(lines []string) string {
+	if len(lines) > d.height {
+		lines = lines[:d.height]
+	}
+	for len(lines) < d.height {
+		lines = append(lines, "")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// sparkline draws the last length values as bars, scaled to the highest one but at least 1 message/second
+func sparkline(values []float64, length int) string {
+	if len(values) > length {
+		values = values[len(values)-length:]
+	}
+	peak := 1.0
+	for _, v := range values {
+		if v > peak {
+			peak = v
+		}
+	}
+	var b strings.Builder
+	b.WriteString(strings.Repeat(" ", length-len(values)))
+	for _, v := range values {
+		i := int(v / peak * float64(len(sparks)-1))
+		if i < 0 {
+			i = 0
+		}
+		b.WriteRune(sparks[i])
+	}
+	return b.String()
+}
+
+// truncate cuts s off at width runes, marking that it was cut off
+func truncate(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	if width < 1 {
+		return ""
+	}
+	return string([]rune(s)[:width-1]) + "…"
+}
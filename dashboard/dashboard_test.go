@@ -0,0 +1,66 @@
+package dashboard
+
+import (
+	"harubot/admin"
+	decisionlog "harubot/decision-log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSparkline(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		length int
+		want   string
+	}{
+		{"empty", []float64{}, 3, "   "},
+		{"slow chat stays low", []float64{0, 0.5, 1}, 3, "▁▄█"},
+		{"scaled to peak", []float64{2, 4, 8}, 3, "▂▄█"},
+		{"padded", []float64{1}, 3, "  █"},
+		{"only latest", []float64{8, 0, 1}, 2, "▁█"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sparkline(tt.values, tt.length); got != tt.want {
+				t.Errorf("sparkline() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"short", 10, "short"},
+		{"exactly", 7, "exactly"},
+		{"too long", 5, "too …"},
+		{"하루이스와이푸", 4, "하루이…"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.s, func(t *testing.T) {
+			if got := truncate(tt.s, tt.width); got != tt.want {
+				t.Errorf("truncate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDashboard_RenderShortTerminal(t *testing.T) {
+	now := time.Now()
+	status := &admin.Status{Time: now, Channels: []admin.Channel{{Name: "xqc"}, {Name: "forsen"}}}
+	for i := 0; i < 12; i++ {
+		status.Outbound = append(status.Outbound, admin.OutboundMessage{Channel: "xqc", Description: "autoreply", Due: now.Add(time.Second)})
+	}
+	decisions := []decisionlog.Decision{{Time: now, Action: "echo"}, {Time: now, Action: "skip"}}
+	for _, height := range []int{0, 1, 3, 8} {
+		d := New(nil, nil, 80, height)
+		if got := strings.Count(d.Render(status, decisions), "\n") + 1; height > 0 && got != height {
+			t.Errorf("Render() at height %d drew %d lines", height, got)
+		}
+	}
+}
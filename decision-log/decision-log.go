@@ -0,0 +1,69 @@
+package decisionlog
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	ActionEcho      = "echo"
+	ActionAutoReply = "autoreply"
+	ActionSkip      = "skip"
+	ActionCommand   = "command"
+	ActionPause     = "pause"
+	ActionResume    = "resume"
+	ActionIncident  = "incident"
+	ActionStream    = "stream"
+)
+
+// Decision is something the bot did or deliberately didn't do, and why
+type Decision struct {
+	Time    time.Time `json:"time"`
+	Channel string    `json:"channel"`
+	Action  string    `json:"action"`
+	Message string    `json:"message"`
+	Reason  string    `json:"reason"`
+}
+
+// Log keeps the most recent decisions in a ring buffer
+type Log struct {
+	decisions []Decision
+	next      int
+	full      bool
+	lock      sync.Mutex
+}
+
+func NewLog(capacity int) *Log {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &Log{
+		decisions: make([]Decision, capacity),
+	}
+}
+
+// Add records d, overwriting the oldest decision once the log is full
+func (l *Log) Add(d Decision) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.decisions[l.next] = d
+	l.next = (l.next + 1) % len(l.decisions)
+	if l.next == 0 {
+		l.full = true
+	}
+}
+
+// Recent returns up to limit of the latest decisions, oldest first. A limit of 0 or less returns all of them.
+func (l *Log) Recent(limit int) []Decision {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	ordered := []Decision{}
+	if l.full {
+		ordered = append(ordered, l.decisions[l.next:]...)
+	}
+	ordered = append(ordered, l.decisions[:l.next]...)
+	if limit > 0 && len(ordered) > limit {
+		ordered = ordered[len(ordered)-limit:]
+	}
+	return ordered
+}
@@ -0,0 +1,40 @@
+package decisionlog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func messages(decisions []Decision) []string {
+	r := []string{}
+	for _, d := range decisions {
+		r = append(r, d.Message)
+	}
+	return r
+}
+
+func TestLog_Recent(t *testing.T) {
+	tests := []struct {
+		name  string
+		added []string
+		limit int
+		want  []string
+	}{
+		{"empty", []string{}, 0, []string{}},
+		{"not full", []string{"a", "b"}, 0, []string{"a", "b"}},
+		{"wrapped", []string{"a", "b", "c", "d", "e"}, 0, []string{"b", "c", "d", "e"}},
+		{"exactly full", []string{"a", "b", "c", "d"}, 0, []string{"a", "b", "c", "d"}},
+		{"limited", []string{"a", "b", "c", "d", "e"}, 2, []string{"d", "e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLog(4)
+			for _, m := range tt.added {
+				l.Add(Decision{Message: m})
+			}
+			if got := messages(l.Recent(tt.limit)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Recent() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package deviceauth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"harubot/clock"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultDeviceURL = "https://id.twitch.tv/oauth2/device"
+	DefaultTokenURL  = "https://id.twitch.tv/oauth2/token"
+	deviceGrantType  = "urn:ietf:params:oauth:grant-type:device_code"
+)
+
+// Scopes are everything the bot needs its user token for
+var Scopes = []string{
+	"chat:read",
+	"chat:edit",
+	"user:read:subscriptions",
+	"user:manage:chat_color",
+	"user:read:chat",
+	"user:write:chat",
+}
+
+type Config struct {
+	DeviceURL    string // defaults to DefaultDeviceURL
+	TokenURL     string // defaults to DefaultTokenURL
+	ClientID     string
+	ClientSecret string // only sent if set, public clients don't have one
+	Scopes       []string
+}
+
+// Authorization is what the user has to be shown to approve the bot
+type Authorization struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURI string `json:"verification_uri"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+type Token struct {
+	AccessToken  string   `json:"access_token"`
+	RefreshToken string   `json:"refresh_token"`
+	ExpiresIn    int      `json:"expires_in"`
+	Scope        []string `json:"scope"`
+}
+
+type errorResponse struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+// Flow runs the OAuth device authorization grant against Twitch
+type Flow struct {
+	config     Config
+	httpClient *http.Client
+	clock      clock.Clock
+}
+
+func NewFlow(config Config, clk clock.Clock) *Flow {
+	if config.DeviceURL == "" {
+		config.DeviceURL = DefaultDeviceURL
+	}
+	if config.TokenURL == "" {
+		config.TokenURL = DefaultTokenURL
+	}
+	if len(config.Scopes) == 0 {
+		config.Scopes = Scopes
+	}
+	return &Flow{
+		config:     config,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+		clock:      clk,
+	}
+}
+
+// Start requests a user code for the user to enter at the verification URI
+func (f *Flow) Start(ctx context.Context) (*Authorization, error) {
+	data := url.Values{}
+	data.Set("client_id", f.config.ClientID)
+	data.Set("scopes", strings.Join(f.config.Scopes, " "))
+	body, status, err := f.post(ctx, f.config.DeviceURL, data)
+	if err != nil {
+		return nil, err
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("failed to start device authorization: %d %s", status, body)
+	}
+	a := &Authorization{}
+	err = json.Unmarshal(body, a)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Wait polls until the user has approved (or denied) the authorization, or it expires
+func (f *Flow) Wait(ctx context.Context, a *Authorization) (*Token, error) {
+	interval := time.Duration(a.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	expiry := f.clock.Now().Add(time.Duration(a.ExpiresIn) * time.Second)
+
+	data := url.Values{}
+	data.Set("client_id", f.config.ClientID)
+	if f.config.ClientSecret != "" {
+		data.Set("client_secret", f.config.ClientSecret)
+	}
+	data.Set("device_code", a.DeviceCode)
+	data.Set("scopes", strings.Join(f.config.Scopes, " "))
+	data.Set("grant_type", deviceGrantType)
+
+	for {
+		err := clock.SleepContext(ctx, f.clock, interval)
+		if err != nil {
+			return nil, err
+		}
+		if f.clock.Now().After(expiry) {
+			return nil, errors.New("device code expired before it was approved")
+		}
+
+		body, status, err := f.post(ctx, f.config.TokenURL, data)
+		if err != nil {
+			return nil, err
+		}
+		if status == http.StatusOK {
+			t := &Token{}
+			err = json.Unmarshal(body, t)
+			if err != nil {
+				return nil, err
+			}
+			return t, nil
+		}
+
+		e := errorResponse{}
+		_ = json.Unmarshal(body, &e)
+		switch e.Message {
+		case "authorization_pending":
+			continue
+		case "slow_down":
+			interval += 5 * time.Second
+			continue
+		default:
+			return nil, fmt.Errorf("failed to get token: %d %s", status, body)
+		}
+	}
+}
+
+func (f *Flow) post(ctx context.Context, endpoint string, data url.Values) ([]byte, int, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, 0, err
+	}
+	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	response, err := f.httpClient.Do(request)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	return body, response.StatusCode, nil
+}
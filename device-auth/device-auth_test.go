@@ -0,0 +1,77 @@
+package deviceauth
+
+import (
+	"context"
+	"fmt"
+	"harubot/clock"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestFlow(t *testing.T) {
+	tokenResponses := []string{"authorization_pending", "slow_down", ""}
+	tokenRequests := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("scopes") != "chat:read chat:edit user:read:subscriptions user:manage:chat_color user:read:chat user:write:chat" {
+			t.Errorf("requested scopes = %q", r.FormValue("scopes"))
+		}
+		w.Write([]byte(`{"device_code":"device-code","user_code":"ABCDEFGH","verification_uri":"https://www.twitch.tv/activate","expires_in":1800,"interval":5}`))
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("device_code") != "device-code" || r.FormValue("grant_type") != deviceGrantType {
+			t.Errorf("unexpected token request: %v", r.Form)
+		}
+		message := tokenResponses[tokenRequests]
+		tokenRequests++
+		if message != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"status":400,"message":%q}`, message)
+			return
+		}
+		w.Write([]byte(`{"access_token":"access-token","refresh_token":"refresh-token","expires_in":14000}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	start := time.Unix(0, 0)
+	clk := clock.NewVirtual(start)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case <-time.After(time.Millisecond):
+				clk.AdvanceToNextWaiter()
+			}
+		}
+	}()
+
+	flow := NewFlow(Config{
+		DeviceURL: server.URL + "/device",
+		TokenURL:  server.URL + "/token",
+		ClientID:  "client-id",
+	}, clk)
+	a, err := flow.Start(context.Background())
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if a.UserCode != "ABCDEFGH" {
+		t.Errorf("UserCode = %q, want %q", a.UserCode, "ABCDEFGH")
+	}
+	token, err := flow.Wait(context.Background(), a)
+	if err != nil {
+		t.Fatalf("Wait() error = %v", err)
+	}
+	if token.AccessToken != "access-token" || token.RefreshToken != "refresh-token" {
+		t.Errorf("Wait() = %+v", token)
+	}
+	// 5s until the first poll, 5s after authorization_pending, 10s after slow_down
+	if got, want := clk.Since(start), 20*time.Second; got != want {
+		t.Errorf("polled for %s, want %s", got, want)
+	}
+}
@@ -1,49 +1,62 @@
 package emotes
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"github.com/forPelevin/gomoji"
 	log "github.com/sirupsen/logrus"
+	"harubot/clock"
+	"harubot/credentials"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
 type Cache struct {
-	emotesByChannel map[string]map[string]bool // cached
-	globalEmotes    map[string]bool            // cached
+	emotesByChannel map[string]map[string]bool // cached, replaced as a whole by every refresh
+	globalEmotes    map[string]bool            // cached, replaced as a whole by every refresh
 	channelIds      map[string]string          // cached
-	channels        []string                   // passed in
-	selfUserId      string                     // passed in
+	channels        []string                   // passed in, then changed by AddChannel and RemoveChannel
+	lock            sync.RWMutex               // guards emotesByChannel, globalEmotes, channelIds and channels
+	offline         bool                       // never fetches anything, for caches loaded from a snapshot
+	baseURL         string
+	selfUserId      string      // passed in
+	clock           clock.Clock // passed in
+	twitch          *twitchClient
+	refreshRequests chan struct{}
+	subscribers     []func()
+	subscribersLock sync.Mutex
 }
 
-func NewCache(channels []string, selfUserId string) *Cache {
+func NewCache(channels []string, selfUserId string, provider *credentials.Provider, clk clock.Clock) *Cache {
 	ebc := map[string]map[string]bool{}
 	for _, channel := range channels {
 		ebc[channel] = map[string]bool{}
 	}
 	newCache := &Cache{
-		channels:        channels,
+		channels:        append([]string{}, channels...),
 		emotesByChannel: ebc,
 		globalEmotes:    map[string]bool{},
 		channelIds:      map[string]string{},
+		baseURL:         emotesAPIBaseURL(),
 		selfUserId:      selfUserId,
+		clock:           clk,
+		refreshRequests: make(chan struct{}, 1),
 	}
+	tc, err := newTwitchClient(provider)
+	if err != nil {
+		log.Errorf("failed to create twitch client: %s", err)
+	}
+	newCache.twitch = tc
 	newCache.fetchChannelIDs(channels)
 	return newCache
 }
 
-func (c *Cache) clear() {
-	for channel := range c.emotesByChannel {
-		c.emotesByChannel[channel] = map[string]bool{}
-	}
-	c.globalEmotes = map[string]bool{}
-}
-
 const (
 	emotesAPIEndpoint         = "https://emotes.adamcy.pl"
 	emotesAPIVersion          = "v1"
@@ -55,9 +68,78 @@ func emotesAPIBaseURL() string {
 	return fmt.Sprintf("%s/%s", emotesAPIEndpoint, emotesAPIVersion)
 }
 
-func (c *Cache) fetchEmotes() {
-	c.fetchGlobalEmotes()
-	c.fetchChannelEmotes(c.channels)
+// fetchEmotes fetches all emotes into new maps, so readers keep seeing the old ones until they're swapped in
+func (c *Cache) fetchEmotes(ctx context.Context) (map[string]bool, map[string]map[string]bool) {
+	c.lock.RLock()
+	channels := append([]string{}, c.channels...)
+	c.lock.RUnlock()
+	globalEmotes := map[string]bool{}
+	emotesByChannel := map[string]map[string]bool{}
+	for _, channel := range channels {
+		emotesByChannel[channel] = map[string]bool{}
+	}
+	c.fetchGlobalEmotes(ctx, globalEmotes)
+	c.fetchChannelEmotes(ctx, channels, emotesByChannel)
+	return globalEmotes, emotesByChannel
+}
+
+// AddChannel starts caching the emotes of channel, fetching its id if it's unknown and its emotes right away
+func (c *Cache) AddChannel(channel string) {
+	c.lock.Lock()
+	if c.cachesChannel(channel) {
+		c.lock.Unlock()
+		return
+	}
+	c.channels = append(c.channels, channel)
+	_, hasID := c.channelIds[channel]
+	c.lock.Unlock()
+
+	emotes := map[string]bool{}
+	if !c.offline {
+		if !hasID {
+			getChannelIDResp, err := c.getChannelID(channel)
+			if err != nil {
+				log.Errorf("failed to get channel ID for %s: %s", channel, err)
+			} else {
+				c.lock.Lock()
+				c.channelIds[channel] = strconv.Itoa(getChannelIDResp.ID)
+				c.lock.Unlock()
+			}
+		}
+		c.fetchEmotesOfChannel(channel, emotes)
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, cached := c.emotesByChannel[channel]; cached && len(emotes) == 0 {
+		return // already fetched by a refresh, or cached in the snapshot
+	}
+	emotesByChannel := make(map[string]map[string]bool, len(c.emotesByChannel)+1)
+	for known, knownEmotes := range c.emotesByChannel {
+		emotesByChannel[known] = knownEmotes
+	}
+	emotesByChannel[channel] = emotes
+	c.emotesByChannel = emotesByChannel
+}
+
+// RemoveChannel stops caching the emotes of channel
+func (c *Cache) RemoveChannel(channel string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	channels := []string{}
+	for _, known := range c.channels {
+		if known != channel {
+			channels = append(channels, known)
+		}
+	}
+	c.channels = channels
+	emotesByChannel := make(map[string]map[string]bool, len(c.emotesByChannel))
+	for known, knownEmotes := range c.emotesByChannel {
+		if known != channel {
+			emotesByChannel[known] = knownEmotes
+		}
+	}
+	c.emotesByChannel = emotesByChannel
 }
 
 func doGetRequestAndRead(url string, headers map[string]string) ([]byte, error) {
@@ -94,7 +176,7 @@ type getChannelIDResponse struct {
 }
 
 func (c *Cache) getChannelID(channel string) (*getChannelIDResponse, error) {
-	bodyBytes, err := doGetRequestAndRead(fmt.Sprintf("%s/channel/%s/id", emotesAPIBaseURL(), channel), map[string]string{})
+	bodyBytes, err := doGetRequestAndRead(fmt.Sprintf("%s/channel/%s/id", c.baseURL, channel), map[string]string{})
 	if err != nil {
 		return nil, err
 	}
@@ -143,7 +225,7 @@ type getGlobalEmotesEmote struct {
 }
 
 func (c *Cache) getGlobalEmotes() (*getGlobalEmotesResponse, error) {
-	bodyBytes, err := doGetRequestAndRead(fmt.Sprintf("%s/global/emotes/%s", emotesAPIBaseURL(), allServicesRegex), map[string]string{})
+	bodyBytes, err := doGetRequestAndRead(fmt.Sprintf("%s/global/emotes/%s", c.baseURL, allServicesRegex), map[string]string{})
 	if err != nil {
 		return nil, err
 	}
@@ -155,23 +237,28 @@ func (c *Cache) getGlobalEmotes() (*getGlobalEmotesResponse, error) {
 	return &responseStruct, nil
 }
 
-func (c *Cache) fetchGlobalEmotes() {
+func (c *Cache) fetchGlobalEmotes(ctx context.Context, into map[string]bool) {
 	globalEmotes, err := c.getGlobalEmotes()
 	if err != nil {
 		log.Errorf("failed to get global emotes: %s", err)
 		return
 	}
 	for _, globalEmote := range *globalEmotes {
-		c.globalEmotes[globalEmote.Code] = true
+		into[globalEmote.Code] = true
 	}
 
-	tc, err := newTwitchClient()
-	if err != nil {
-		log.Errorf("failed to create twitch client: %s", err)
+	if c.twitch == nil {
+		return
 	}
+	c.lock.RLock()
+	channelIds := make([]string, 0, len(c.channelIds))
 	for _, channelId := range c.channelIds {
-		if subscriptionTier := checkSub(channelId, c.selfUserId); subscriptionTier != SubscriptionTier_NoSubscription {
-			emotes, err := tc.getChannelEmotes(channelId)
+		channelIds = append(channelIds, channelId)
+	}
+	c.lock.RUnlock()
+	for _, channelId := range channelIds {
+		if subscriptionTier := c.twitch.checkSub(channelId, c.selfUserId); subscriptionTier != SubscriptionTier_NoSubscription {
+			emotes, err := c.twitch.getChannelEmotes(channelId)
 			if err != nil {
 				log.Errorf("failed to get twitch emotes for channel %s: %s", channelId, err)
 				continue
@@ -182,31 +269,20 @@ func (c *Cache) fetchGlobalEmotes() {
 				}
 				emoteSubscriptionTier := subscriptionTierStringToInt(emote.Tier)
 				if emoteSubscriptionTier <= subscriptionTier {
-					c.globalEmotes[emote.Name] = true
+					into[emote.Name] = true
 				}
 			}
-			time.Sleep(5 * time.Second) // avoid rate limits
+			if clock.SleepContext(ctx, c.clock, 5*time.Second) != nil { // avoid rate limits
+				return
+			}
 		}
 	}
 }
 
-func readSecrets() (*secrets, error) {
-	secretsBytes, err := ioutil.ReadFile("./secrets.json")
-	if err != nil {
-		return nil, err
-	}
-	s := &secrets{}
-	err = json.Unmarshal(secretsBytes, s)
-	if err != nil {
-		return nil, err
-	}
-	return s, nil
-}
-
 type getChannelEmotesResponse getGlobalEmotesResponse // currently same structure
 
 func (c *Cache) getChannelEmotes(channelID string, servicesRegex string) (*getChannelEmotesResponse, error) {
-	bodyBytes, err := doGetRequestAndRead(fmt.Sprintf("%s/channel/%s/emotes/%s", emotesAPIBaseURL(), channelID, servicesRegex), map[string]string{})
+	bodyBytes, err := doGetRequestAndRead(fmt.Sprintf("%s/channel/%s/emotes/%s", c.baseURL, channelID, servicesRegex), map[string]string{})
 	if err != nil {
 		return nil, err
 	}
@@ -218,56 +294,143 @@ func (c *Cache) getChannelEmotes(channelID string, servicesRegex string) (*getCh
 	return &responseStruct, nil
 }
 
-func (c *Cache) fetchChannelEmotes(channels []string) {
+func (c *Cache) fetchChannelEmotes(ctx context.Context, channels []string, into map[string]map[string]bool) {
 	for _, channel := range channels {
-		channelID, ok := c.channelIds[channel]
-		if !ok {
-			log.Errorf("failed to get channel emotes for %s: failed to find channel id in cache", channel)
-			continue
-		}
-		channelEmotes, err := c.getChannelEmotes(channelID, allServicesButTwitchRegex)
-		if err != nil {
-			log.Errorf("failed to get channel emotes for %s: %s", channel, err)
+		if !c.fetchEmotesOfChannel(channel, into[channel]) {
 			continue
 		}
-		for _, channelEmote := range *channelEmotes {
-			c.emotesByChannel[channel][channelEmote.Code] = true
+		if clock.SleepContext(ctx, c.clock, 350*time.Millisecond) != nil { // avoid rate limits
+			return
 		}
-		time.Sleep(350 * time.Millisecond) // avoid rate limits
 	}
 }
 
-func (c *Cache) RoutinelyRefreshCache(interval int) {
-	time.Sleep(1 * time.Minute)
-	for {
-		c.clear()
-		c.fetchEmotes()
-		time.Sleep(time.Duration(interval) * time.Minute)
+// fetchEmotesOfChannel fetches the emotes of channel into into and reports whether that succeeded
+func (c *Cache) fetchEmotesOfChannel(channel string, into map[string]bool) bool {
+	c.lock.RLock()
+	channelID, ok := c.channelIds[channel]
+	c.lock.RUnlock()
+	if !ok {
+		log.Errorf("failed to get channel emotes for %s: failed to find channel id in cache", channel)
+		return false
+	}
+	channelEmotes, err := c.getChannelEmotes(channelID, allServicesButTwitchRegex)
+	if err != nil {
+		log.Errorf("failed to get channel emotes for %s: %s", channel, err)
+		return false
+	}
+	for _, channelEmote := range *channelEmotes {
+		into[channelEmote.Code] = true
 	}
+	return true
 }
 
-func (c *Cache) IsWordAnEmoteInChannel(word string, channel string) bool {
-	if gomoji.ContainsEmoji(word) {
-		return true
+// RoutinelyRefreshCache refetches all emotes every interval minutes and, if snapshotPath isn't empty, saves them there
+func (c *Cache) RoutinelyRefreshCache(ctx context.Context, interval int, snapshotPath string) {
+	if clock.SleepContext(ctx, c.clock, 1*time.Minute) != nil {
+		return
 	}
-	for globalEmote := range c.globalEmotes {
-		if word == globalEmote {
-			return true
+	for {
+		globalEmotes, emotesByChannel := c.fetchEmotes(ctx)
+		if ctx.Err() != nil {
+			return // don't keep an emote cache that was only partially refetched
+		}
+		c.lock.Lock()
+		for channel := range emotesByChannel {
+			if !c.cachesChannel(channel) {
+				delete(emotesByChannel, channel) // removed while refreshing
+			}
+		}
+		for _, channel := range c.channels {
+			if _, ok := emotesByChannel[channel]; !ok {
+				emotesByChannel[channel] = c.emotesByChannel[channel] // added while refreshing
+			}
+		}
+		c.globalEmotes, c.emotesByChannel = globalEmotes, emotesByChannel
+		c.lock.Unlock()
+		if snapshotPath != "" {
+			err := c.WriteSnapshot(snapshotPath)
+			if err != nil {
+				log.Errorf("failed to write emote snapshot: %s", err)
+			}
+		}
+		c.notifySubscribers()
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.clock.After(time.Duration(interval) * time.Minute):
+		case <-c.refreshRequests:
+			log.Info("refreshing emote cache on request")
 		}
 	}
-	for channelEmote := range c.emotesByChannel[channel] {
-		if word == channelEmote {
+}
+
+// cachesChannel reports whether channel is one of the channels, the caller holds the lock
+func (c *Cache) cachesChannel(channel string) bool {
+	for _, known := range c.channels {
+		if known == channel {
 			return true
 		}
 	}
 	return false
 }
 
+// Subscribe registers onRefresh to be called after every complete refetch of the emotes
+func (c *Cache) Subscribe(onRefresh func()) {
+	c.subscribersLock.Lock()
+	defer c.subscribersLock.Unlock()
+	c.subscribers = append(c.subscribers, onRefresh)
+}
+
+func (c *Cache) notifySubscribers() {
+	c.subscribersLock.Lock()
+	subscribers := append([]func(){}, c.subscribers...)
+	c.subscribersLock.Unlock()
+	for _, onRefresh := range subscribers {
+		onRefresh()
+	}
+}
+
+// RequestRefresh makes RoutinelyRefreshCache refetch all emotes right away instead of waiting for the interval to pass
+func (c *Cache) RequestRefresh() {
+	select {
+	case c.refreshRequests <- struct{}{}:
+	default: // a refresh is already pending
+	}
+}
+
+// Emotes returns the cached global emotes and those of channel, sorted
+func (c *Cache) Emotes(channel string) ([]string, []string, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	channelEmotes, ok := c.emotesByChannel[channel]
+	if !ok {
+		return nil, nil, fmt.Errorf("no emotes cached for channel '%s'", channel)
+	}
+	return setToSlice(c.globalEmotes), setToSlice(channelEmotes), nil
+}
+
+func (c *Cache) IsWordAnEmoteInChannel(word string, channel string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return c.isEmote(word, channel)
+}
+
+// isEmote is IsWordAnEmoteInChannel for callers already holding the read lock
+func (c *Cache) isEmote(word string, channel string) bool {
+	if gomoji.ContainsEmoji(word) {
+		return true
+	}
+	return c.globalEmotes[word] || c.emotesByChannel[channel][word]
+}
+
 func (c *Cache) MessageWithOnlyEmotes(message string, channel string) string {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	words := strings.Split(message, " ")
 	emotes := []string{}
 	for _, word := range words {
-		if c.IsWordAnEmoteInChannel(word, channel) {
+		if c.isEmote(word, channel) {
 			emotes = append(emotes, word)
 		}
 	}
@@ -276,9 +439,11 @@ func (c *Cache) MessageWithOnlyEmotes(message string, channel string) string {
 }
 
 func (c *Cache) MessageContainsOnlyEmotes(message string, channel string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	words := strings.Split(message, " ")
 	for _, word := range words {
-		if !c.IsWordAnEmoteInChannel(word, channel) {
+		if !c.isEmote(word, channel) {
 			return false
 		}
 	}
@@ -286,8 +451,10 @@ func (c *Cache) MessageContainsOnlyEmotes(message string, channel string) bool {
 }
 
 func (c *Cache) SentenceContainsEmotes(sentence string, channel string) bool {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
 	for _, w := range strings.Split(sentence, " ") {
-		if c.IsWordAnEmoteInChannel(w, channel) {
+		if c.isEmote(w, channel) {
 			return true
 		}
 	}
@@ -0,0 +1,118 @@
+package emotes
+
+import (
+	"context"
+	"fmt"
+	"harubot/clock"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newTestCache creates a cache of forsen fetching from a fake emote API, which also knows the emotes of xqc
+func newTestCache(t *testing.T, clk clock.Clock) *Cache {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/global/emotes/" + allServicesRegex:
+			fmt.Fprint(w, `[{"code":"KEKW"}]`)
+		case "/channel/1/emotes/" + allServicesButTwitchRegex:
+			fmt.Fprint(w, `[{"code":"forsenE"}]`)
+		case "/channel/xqc/id":
+			fmt.Fprint(w, `{"id":2}`)
+		case "/channel/2/emotes/" + allServicesButTwitchRegex:
+			fmt.Fprint(w, `[{"code":"xqcL"}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return &Cache{
+		emotesByChannel: map[string]map[string]bool{"forsen": {"forsenE": true}},
+		globalEmotes:    map[string]bool{"KEKW": true},
+		channelIds:      map[string]string{"forsen": "1"},
+		baseURL:         server.URL,
+		channels:        []string{"forsen"},
+		clock:           clk,
+		refreshRequests: make(chan struct{}, 1),
+	}
+}
+
+// refresh runs RoutinelyRefreshCache until it refreshed count times
+func refresh(t *testing.T, c *Cache, clk *clock.Virtual, count int32) {
+	var refreshes int32
+	c.Subscribe(func() { atomic.AddInt32(&refreshes, 1) })
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.RoutinelyRefreshCache(ctx, 1, filepath.Join(t.TempDir(), "emotes.json"))
+	}()
+	for atomic.LoadInt32(&refreshes) < count {
+		if !clk.AdvanceToNextWaiter() {
+			time.Sleep(time.Millisecond) // a fetch is in flight
+		}
+	}
+	cancel()
+	<-done
+}
+
+func TestCache_ReadDuringRefresh(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(1000, 0))
+	c := newTestCache(t, clk)
+
+	var readers sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				// a refresh swaps in complete maps, so readers never see a cache that's emptied mid-refresh
+				if !c.MessageContainsOnlyEmotes("KEKW forsenE", "forsen") {
+					t.Errorf("MessageContainsOnlyEmotes() = false during a refresh")
+					return
+				}
+				if _, _, err := c.Emotes("forsen"); err != nil {
+					t.Errorf("Emotes() failed during a refresh: %s", err)
+					return
+				}
+			}
+		}()
+	}
+
+	refresh(t, c, clk, 3)
+	close(stop)
+	readers.Wait()
+}
+
+func TestCache_AddAndRemoveChannel(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(1000, 0))
+	c := newTestCache(t, clk)
+
+	c.AddChannel("xqc")
+	if _, channelEmotes, err := c.Emotes("xqc"); err != nil || !reflect.DeepEqual(channelEmotes, []string{"xqcL"}) {
+		t.Errorf("Emotes(xqc) = %v, %v after adding it, want [xqcL]", channelEmotes, err)
+	}
+	c.RemoveChannel("forsen")
+	if _, _, err := c.Emotes("forsen"); err == nil {
+		t.Errorf("Emotes(forsen) succeeded after removing it")
+	}
+
+	refresh(t, c, clk, 1)
+	if !c.IsWordAnEmoteInChannel("xqcL", "xqc") {
+		t.Errorf("a refresh dropped the emotes of an added channel")
+	}
+	if _, _, err := c.Emotes("forsen"); err == nil {
+		t.Errorf("a refresh fetched the emotes of a removed channel")
+	}
+}
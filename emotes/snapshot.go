@@ -0,0 +1,85 @@
+package emotes
+
+import (
+	"encoding/json"
+	atomicfile "harubot/atomic-file"
+	"harubot/clock"
+	"io/ioutil"
+	"sort"
+)
+
+type snapshot struct {
+	GlobalEmotes    []string            `json:"global-emotes"`
+	EmotesByChannel map[string][]string `json:"emotes-by-channel"`
+	ChannelIds      map[string]string   `json:"channel-ids"`
+}
+
+// WriteSnapshot saves the currently cached emotes so they can be loaded without any network access later on.
+// The file is replaced as a whole, so a crash while writing never leaves a snapshot that can't be read.
+func (c *Cache) WriteSnapshot(path string) error {
+	c.lock.RLock()
+	s := snapshot{
+		GlobalEmotes:    setToSlice(c.globalEmotes),
+		EmotesByChannel: map[string][]string{},
+		ChannelIds:      map[string]string{},
+	}
+	for channel, id := range c.channelIds {
+		s.ChannelIds[channel] = id
+	}
+	for channel, emotes := range c.emotesByChannel {
+		s.EmotesByChannel[channel] = setToSlice(emotes)
+	}
+	c.lock.RUnlock()
+	bytes, err := json.Marshal(&s)
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, bytes, 0644)
+}
+
+// NewCacheFromSnapshot creates a cache from a file written by WriteSnapshot, e.g. for replaying chat logs offline
+func NewCacheFromSnapshot(path string, clk clock.Clock) (*Cache, error) {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s := snapshot{}
+	err = json.Unmarshal(bytes, &s)
+	if err != nil {
+		return nil, err
+	}
+	c := &Cache{
+		emotesByChannel: map[string]map[string]bool{},
+		globalEmotes:    sliceToSet(s.GlobalEmotes),
+		channelIds:      s.ChannelIds,
+		channels:        []string{},
+		offline:         true,
+		clock:           clk,
+		refreshRequests: make(chan struct{}, 1),
+	}
+	if c.channelIds == nil {
+		c.channelIds = map[string]string{}
+	}
+	for channel, emotes := range s.EmotesByChannel {
+		c.emotesByChannel[channel] = sliceToSet(emotes)
+		c.channels = append(c.channels, channel)
+	}
+	return c, nil
+}
+
+func setToSlice(set map[string]bool) []string {
+	s := make([]string, 0, len(set))
+	for k := range set {
+		s = append(s, k)
+	}
+	sort.Strings(s)
+	return s
+}
+
+func sliceToSet(s []string) map[string]bool {
+	set := make(map[string]bool, len(s))
+	for _, k := range s {
+		set[k] = true
+	}
+	return set
+}
@@ -1,25 +1,18 @@
 package emotes
 
 import (
-	"fmt"
 	"github.com/nicklaw5/helix/v2"
 	log "github.com/sirupsen/logrus"
+	"harubot/credentials"
 )
 
 type twitchClient struct {
 	helix *helix.Client
 }
 
-type secrets struct {
-	TwitchAccessToken string `json:"twitch-access-token"`
-	TwitchClientID    string `json:"twitch-client-id"`
-}
-
-func newTwitchClient() (*twitchClient, error) {
-	s, err := readSecrets()
-	if err != nil {
-		return nil, fmt.Errorf("failed to read secrets: %s", err)
-	}
+// newTwitchClient creates a Helix client that switches to the new user access token whenever it's rotated
+func newTwitchClient(provider *credentials.Provider) (*twitchClient, error) {
+	s := provider.Get()
 	helixClient, err := helix.NewClient(&helix.Options{
 		ClientID:        s.TwitchClientID,
 		UserAccessToken: s.TwitchAccessToken,
@@ -27,6 +20,9 @@ func newTwitchClient() (*twitchClient, error) {
 	if err != nil {
 		return nil, err
 	}
+	provider.Subscribe(func(s credentials.Secrets) {
+		helixClient.SetUserAccessToken(s.TwitchAccessToken)
+	})
 	return &twitchClient{
 		helix: helixClient,
 	}, nil
@@ -39,12 +35,7 @@ const (
 	SubscriptionTier_3
 )
 
-func checkSub(broadcasterId, userId string) int {
-	t, err := newTwitchClient()
-	if err != nil {
-		log.Errorf("failed to create twitch client: %s", err)
-		return SubscriptionTier_NoSubscription
-	}
+func (t *twitchClient) checkSub(broadcasterId, userId string) int {
 	resp, err := t.helix.CheckUserSubscription(&helix.UserSubscriptionsParams{
 		BroadcasterID: broadcasterId,
 		UserID:        userId,
@@ -0,0 +1,58 @@
+package eventbus
+
+import (
+	"sync"
+	"time"
+)
+
+// StreamOnline is sent when a channel starts streaming
+type StreamOnline struct {
+	Channel   string
+	StartedAt time.Time
+}
+
+// StreamOffline is sent when a channel stops streaming
+type StreamOffline struct {
+	Channel string
+}
+
+// ChatCleared is sent when a moderator clears the whole chat of a channel
+type ChatCleared struct {
+	Channel string
+}
+
+// EmotesChanged is sent when the emotes the bot may use in a channel changed, e.g. because it got subscribed there
+type EmotesChanged struct {
+	Channel string
+}
+
+// Bus delivers events from their sources to every handler subscribed to their type
+type Bus struct {
+	handlers []func(event any)
+	lock     sync.RWMutex
+}
+
+func New() *Bus {
+	return &Bus{}
+}
+
+// Subscribe registers handler to be called with every published event of type T
+func Subscribe[T any](b *Bus, handler func(event T)) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.handlers = append(b.handlers, func(event any) {
+		if e, ok := event.(T); ok {
+			handler(e)
+		}
+	})
+}
+
+// Publish calls the handlers subscribed to the type of event in the order they subscribed, returning once all of them did
+func (b *Bus) Publish(event any) {
+	b.lock.RLock()
+	handlers := b.handlers
+	b.lock.RUnlock()
+	for _, handle := range handlers {
+		handle(event)
+	}
+}
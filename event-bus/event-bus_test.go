@@ -0,0 +1,23 @@
+package eventbus
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBus_Publish(t *testing.T) {
+	b := New()
+	got := []string{}
+	Subscribe(b, func(e StreamOnline) { got = append(got, "online "+e.Channel) })
+	Subscribe(b, func(e StreamOffline) { got = append(got, "offline "+e.Channel) })
+	Subscribe(b, func(e StreamOnline) { got = append(got, "online again "+e.Channel) })
+
+	b.Publish(StreamOnline{Channel: "xqc"})
+	b.Publish(StreamOffline{Channel: "forsen"})
+	b.Publish(ChatCleared{Channel: "xqc"})
+
+	want := []string{"online xqc", "online again xqc", "offline forsen"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("handled %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	eventbus "harubot/event-bus"
+)
+
+// subscribeToEvents lets the bot react to what EventSub reports
+func (state *state) subscribeToEvents(bus *eventbus.Bus) {
+	eventbus.Subscribe(bus, func(e eventbus.StreamOnline) {
+		if state.streams != nil {
+			state.streams.Set(e.Channel, true)
+		}
+	})
+	eventbus.Subscribe(bus, func(e eventbus.StreamOffline) {
+		if state.streams != nil {
+			state.streams.Set(e.Channel, false)
+		}
+	})
+	eventbus.Subscribe(bus, func(e eventbus.ChatCleared) {
+		// CLEARCHAT from IRC already makes the bot back off, this only confirms it
+		log.WithFields(log.Fields{
+			"channel": e.Channel,
+		}).Debug("eventsub reported a chat clear")
+	})
+	eventbus.Subscribe(bus, func(e eventbus.EmotesChanged) {
+		log.WithFields(log.Fields{
+			"channel": e.Channel,
+		}).Info("emotes changed")
+		state.emoteCache.RequestRefresh()
+	})
+}
+
+// eventSubChannelIDs picks the ids of channels from channelIDs, skipping channels without one
+func eventSubChannelIDs(channels []string, channelIDs map[string]string) map[string]string {
+	ids := map[string]string{}
+	for _, channel := range channels {
+		id, ok := channelIDs[channel]
+		if !ok {
+			log.Warnf("not subscribing to eventsub in #%s, it's missing from channel-ids.json", channel)
+			continue
+		}
+		ids[channel] = id
+	}
+	return ids
+}
@@ -0,0 +1,368 @@
+package eventsub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+	"harubot/clock"
+	eventbus "harubot/event-bus"
+	twitchapi "harubot/twitch-api"
+	"sort"
+	"sync"
+	"time"
+)
+
+const DefaultURL = "wss://eventsub.wss.twitch.tv/ws"
+
+const (
+	welcomeTimeout      = 10 * time.Second
+	keepaliveGrace      = 5 * time.Second // on top of the keepalive timeout Twitch announces
+	minReconnectBackoff = time.Second
+	maxReconnectBackoff = 2 * time.Minute
+	rememberedMessages  = 100 // Twitch may send a message more than once
+)
+
+// Subscriber creates and deletes subscriptions, implemented by twitchapi.Client
+type Subscriber interface {
+	CreateEventSubSubscription(ctx context.Context, subscription twitchapi.EventSubSubscription) (string, error)
+	DeleteEventSubSubscription(ctx context.Context, id string) error
+}
+
+// Subscriptions are the subscriptions the bot needs in the channel with channelID.
+// channel.ban isn't one of them, Twitch only lets the broadcaster authorize it with the channel:moderate scope,
+// which the bot doesn't get in other people's channels; bans of the bot show up in chat as CLEARCHAT anyway.
+// Twitch has no event for the emotes of a user changing, so channel.chat.notification stands in for it,
+// since the bot getting subscribed to a channel is what changes the emotes it may use there.
+func Subscriptions(channelID string, selfUserID string) []twitchapi.EventSubSubscription {
+	broadcaster := map[string]string{"broadcaster_user_id": channelID}
+	asSelf := map[string]string{"broadcaster_user_id": channelID, "user_id": selfUserID}
+	return []twitchapi.EventSubSubscription{
+		{Type: "stream.online", Version: "1", Condition: broadcaster},
+		{Type: "stream.offline", Version: "1", Condition: broadcaster},
+		{Type: "channel.chat.clear", Version: "1", Condition: asSelf},
+		{Type: "channel.chat.notification", Version: "1", Condition: asSelf},
+	}
+}
+
+type metadata struct {
+	MessageID        string `json:"message_id"`
+	MessageType      string `json:"message_type"`
+	SubscriptionType string `json:"subscription_type"`
+}
+
+type session struct {
+	ID                      string `json:"id"`
+	KeepaliveTimeoutSeconds int    `json:"keepalive_timeout_seconds"`
+	ReconnectURL            string `json:"reconnect_url"`
+}
+
+type message struct {
+	Metadata metadata `json:"metadata"`
+	Payload  struct {
+		Session      session `json:"session"`
+		Subscription struct {
+			Type   string `json:"type"`
+			Status string `json:"status"`
+		} `json:"subscription"`
+		Event json.RawMessage `json:"event"`
+	} `json:"payload"`
+}
+
+// Client keeps a websocket session to EventSub open and publishes the events it receives on a bus
+type Client struct {
+	url             string
+	api             Subscriber
+	selfUserID      string
+	bus             *eventbus.Bus
+	clock           clock.Clock
+	dialer          *websocket.Dialer
+	seen            map[string]bool
+	seenOrder       []string
+	channelIDs      map[string]string   // of the channels to subscribe in, keyed by login
+	sessionID       string              // of the current session, empty while there's none
+	subscriptionIDs map[string][]string // of the subscriptions created in the current session, by channel
+	lock            sync.Mutex          // guards the fields above and is held while subscribing, so no channel is subscribed twice
+}
+
+// NewClient creates a client connecting to url, or DefaultURL if it's empty, subscribing in the channels with channelIDs, keyed by login
+func NewClient(url string, api Subscriber, channelIDs map[string]string, selfUserID string, bus *eventbus.Bus, clk clock.Clock) *Client {
+	if url == "" {
+		url = DefaultURL
+	}
+	ids := map[string]string{}
+	for channel, id := range channelIDs {
+		ids[channel] = id
+	}
+	return &Client{
+		url:             url,
+		api:             api,
+		selfUserID:      selfUserID,
+		bus:             bus,
+		clock:           clk,
+		dialer:          &websocket.Dialer{HandshakeTimeout: welcomeTimeout},
+		seen:            map[string]bool{},
+		channelIDs:      ids,
+		subscriptionIDs: map[string][]string{},
+	}
+}
+
+// AddChannel subscribes in channel with id, right away if a session is open and otherwise once one is
+func (c *Client) AddChannel(ctx context.Context, channel string, id string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if _, ok := c.channelIDs[channel]; ok {
+		return
+	}
+	c.channelIDs[channel] = id
+	if c.sessionID != "" {
+		c.subscribeIn(ctx, channel)
+	}
+}
+
+// RemoveChannel deletes the subscriptions in channel
+func (c *Client) RemoveChannel(ctx context.Context, channel string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.channelIDs, channel)
+	for _, id := range c.subscriptionIDs[channel] {
+		err := c.api.DeleteEventSubSubscription(ctx, id)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"channel": channel,
+				"id":      id,
+			}).Warnf("failed to unsubscribe from eventsub: %s", err)
+		}
+	}
+	delete(c.subscriptionIDs, channel)
+}
+
+// Run keeps a session open until ctx is cancelled, starting a new one with fresh subscriptions whenever it's lost
+func (c *Client) Run(ctx context.Context) {
+	backoff := minReconnectBackoff
+	for {
+		started := c.clock.Now()
+		err := c.runSession(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if c.clock.Now().Sub(started) > maxReconnectBackoff {
+			backoff = minReconnectBackoff
+		}
+		log.WithFields(log.Fields{
+			"backoff": backoff,
+		}).Errorf("eventsub session ended: %s", err)
+		if clock.SleepContext(ctx, c.clock, backoff) != nil {
+			return
+		}
+		backoff *= 2
+		if backoff > maxReconnectBackoff {
+			backoff = maxReconnectBackoff
+		}
+	}
+}
+
+// runSession connects, subscribes and reads until the session fails, following reconnect messages on the way
+func (c *Client) runSession(ctx context.Context) error {
+	conn, s, err := c.connect(ctx, c.url)
+	if err != nil {
+		return err
+	}
+	c.subscribe(ctx, s.ID)
+	defer c.setSession("") // subscriptions end with their session
+	for {
+		reconnectURL, err := c.read(ctx, conn, s)
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		// subscriptions move to the new connection by themselves, the old one is only closed once it's welcomed
+		newConn, newSession, err := c.connect(ctx, reconnectURL)
+		conn.Close()
+		if err != nil {
+			return err
+		}
+		log.Info("eventsub session reconnected")
+		conn, s = newConn, newSession
+		c.setSession(s.ID)
+	}
+}
+
+// connect opens a connection to url and waits for its welcome message
+func (c *Client) connect(ctx context.Context, url string) (*websocket.Conn, session, error) {
+	conn, _, err := c.dialer.DialContext(ctx, url, nil)
+	if err != nil {
+		return nil, session{}, fmt.Errorf("failed to connect to %s: %s", url, err)
+	}
+	conn.SetReadDeadline(time.Now().Add(welcomeTimeout))
+	m := message{}
+	err = conn.ReadJSON(&m)
+	if err != nil {
+		conn.Close()
+		return nil, session{}, fmt.Errorf("failed to read welcome message: %s", err)
+	}
+	if m.Metadata.MessageType != "session_welcome" {
+		conn.Close()
+		return nil, session{}, fmt.Errorf("expected a welcome message, got %q", m.Metadata.MessageType)
+	}
+	log.WithFields(log.Fields{
+		"session": m.Payload.Session.ID,
+	}).Info("eventsub session started")
+	return conn, m.Payload.Session, nil
+}
+
+// subscribe creates the subscriptions of every channel for the new session with sessionID
+func (c *Client) subscribe(ctx context.Context, sessionID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sessionID = sessionID
+	c.subscriptionIDs = map[string][]string{}
+	channels := make([]string, 0, len(c.channelIDs))
+	for channel := range c.channelIDs {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	for _, channel := range channels {
+		c.subscribeIn(ctx, channel)
+	}
+}
+
+// subscribeIn creates the subscriptions of channel for the current session, those that fail, e.g. for lack of permissions, are logged and skipped.
+// The lock has to be held.
+func (c *Client) subscribeIn(ctx context.Context, channel string) {
+	for _, subscription := range Subscriptions(c.channelIDs[channel], c.selfUserID) {
+		subscription.Transport = twitchapi.EventSubTransport{Method: "websocket", SessionID: c.sessionID}
+		id, err := c.api.CreateEventSubSubscription(ctx, subscription)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"type":      subscription.Type,
+				"condition": subscription.Condition,
+			}).Warnf("failed to subscribe to eventsub: %s", err)
+			continue
+		}
+		c.subscriptionIDs[channel] = append(c.subscriptionIDs[channel], id)
+	}
+}
+
+// setSession moves the subscriptions to the session with sessionID after a reconnect, or forgets them once the session is lost if it's empty
+func (c *Client) setSession(sessionID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.sessionID = sessionID
+	if sessionID == "" {
+		c.subscriptionIDs = map[string][]string{}
+	}
+}
+
+// read handles messages until the connection fails or Twitch asks to reconnect, returning the URL to reconnect to
+func (c *Client) read(ctx context.Context, conn *websocket.Conn, s session) (string, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	timeout := time.Duration(s.KeepaliveTimeoutSeconds)*time.Second + keepaliveGrace
+	for {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		m := message{}
+		err := conn.ReadJSON(&m)
+		if err != nil {
+			return "", fmt.Errorf("failed to read message: %s", err)
+		}
+		switch m.Metadata.MessageType {
+		case "session_keepalive": // only there to move the read deadline
+		case "session_reconnect":
+			return m.Payload.Session.ReconnectURL, nil
+		case "revocation":
+			log.WithFields(log.Fields{
+				"type":   m.Payload.Subscription.Type,
+				"status": m.Payload.Subscription.Status,
+			}).Warn("eventsub subscription revoked")
+		case "notification":
+			if c.isDuplicate(m.Metadata.MessageID) {
+				continue
+			}
+			event, err := c.toEvent(m.Metadata.SubscriptionType, m.Payload.Event)
+			if err != nil {
+				log.Errorf("failed to parse %s event: %s", m.Metadata.SubscriptionType, err)
+				continue
+			}
+			if event != nil {
+				c.bus.Publish(event)
+			}
+		default:
+			log.WithFields(log.Fields{
+				"type": m.Metadata.MessageType,
+			}).Debug("ignoring eventsub message")
+		}
+	}
+}
+
+func (c *Client) isDuplicate(messageID string) bool {
+	if c.seen[messageID] {
+		return true
+	}
+	c.seen[messageID] = true
+	c.seenOrder = append(c.seenOrder, messageID)
+	if len(c.seenOrder) > rememberedMessages {
+		delete(c.seen, c.seenOrder[0])
+		c.seenOrder = c.seenOrder[1:]
+	}
+	return false
+}
+
+type channelEvent struct {
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+}
+
+type streamOnlineEvent struct {
+	BroadcasterUserLogin string    `json:"broadcaster_user_login"`
+	StartedAt            time.Time `json:"started_at"`
+}
+
+type chatNotificationEvent struct {
+	BroadcasterUserLogin string `json:"broadcaster_user_login"`
+	ChatterUserID        string `json:"chatter_user_id"`
+	NoticeType           string `json:"notice_type"`
+	SubGift              *struct {
+		RecipientUserID string `json:"recipient_user_id"`
+	} `json:"sub_gift"`
+}
+
+// toEvent turns the event of a notification into the bus event for it, or nil if the bot doesn't care about it
+func (c *Client) toEvent(subscriptionType string, raw json.RawMessage) (any, error) {
+	switch subscriptionType {
+	case "stream.online":
+		e := streamOnlineEvent{}
+		err := json.Unmarshal(raw, &e)
+		return eventbus.StreamOnline{Channel: e.BroadcasterUserLogin, StartedAt: e.StartedAt}, err
+	case "stream.offline":
+		e := channelEvent{}
+		err := json.Unmarshal(raw, &e)
+		return eventbus.StreamOffline{Channel: e.BroadcasterUserLogin}, err
+	case "channel.chat.clear":
+		e := channelEvent{}
+		err := json.Unmarshal(raw, &e)
+		return eventbus.ChatCleared{Channel: e.BroadcasterUserLogin}, err
+	case "channel.chat.notification":
+		e := chatNotificationEvent{}
+		err := json.Unmarshal(raw, &e)
+		if err != nil {
+			return nil, err
+		}
+		// only a proxy: emotes the bot gains or loses any other way, like a subscription running out, wait for the next refresh
+		subscribed := (e.NoticeType == "sub" || e.NoticeType == "resub") && e.ChatterUserID == c.selfUserID
+		gifted := e.NoticeType == "sub_gift" && e.SubGift != nil && e.SubGift.RecipientUserID == c.selfUserID
+		if !subscribed && !gifted {
+			return nil, nil
+		}
+		return eventbus.EmotesChanged{Channel: e.BroadcasterUserLogin}, nil
+	}
+	return nil, nil
+}
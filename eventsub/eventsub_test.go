@@ -0,0 +1,183 @@
+package eventsub
+
+import (
+	"context"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"harubot/clock"
+	eventbus "harubot/event-bus"
+	twitchapi "harubot/twitch-api"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type fakeSubscriber struct {
+	sessions []string
+	active   map[string]twitchapi.EventSubSubscription // by id
+	lock     sync.Mutex
+}
+
+func (f *fakeSubscriber) CreateEventSubSubscription(ctx context.Context, subscription twitchapi.EventSubSubscription) (string, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.sessions = append(f.sessions, subscription.Transport.SessionID)
+	id := fmt.Sprintf("sub-%d", len(f.sessions))
+	if f.active == nil {
+		f.active = map[string]twitchapi.EventSubSubscription{}
+	}
+	f.active[id] = subscription
+	return id, nil
+}
+
+func (f *fakeSubscriber) DeleteEventSubSubscription(ctx context.Context, id string) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if _, ok := f.active[id]; !ok {
+		return fmt.Errorf("no subscription %s", id)
+	}
+	delete(f.active, id)
+	return nil
+}
+
+// channels returns how many active subscriptions there are in each channel by its id
+func (f *fakeSubscriber) channels() map[string]int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	channels := map[string]int{}
+	for _, s := range f.active {
+		channels[s.Condition["broadcaster_user_id"]]++
+	}
+	return channels
+}
+
+func welcome(sessionID string) string {
+	return fmt.Sprintf(`{"metadata":{"message_id":"w-%s","message_type":"session_welcome"},"payload":{"session":{"id":%q,"keepalive_timeout_seconds":10}}}`, sessionID, sessionID)
+}
+
+func notification(messageID string, subscriptionType string, event string) string {
+	return fmt.Sprintf(`{"metadata":{"message_id":%q,"message_type":"notification","subscription_type":%q},"payload":{"event":%s}}`, messageID, subscriptionType, event)
+}
+
+// standIn serves EventSub at /ws, sending the messages of the first connection and, after a reconnect to /reconnect, those of the second.
+// RECONNECT_URL in messages is replaced with the URL of /reconnect.
+func standIn(t *testing.T, first []string, second []string) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	serve := func(messages []string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			conn, err := upgrader.Upgrade(w, r, nil)
+			if err != nil {
+				t.Errorf("failed to upgrade: %s", err)
+				return
+			}
+			defer conn.Close()
+			reconnectURL := fmt.Sprintf("ws://%s/reconnect", r.Host)
+			for _, m := range messages {
+				m = strings.ReplaceAll(m, "RECONNECT_URL", reconnectURL)
+				if conn.WriteMessage(websocket.TextMessage, []byte(m)) != nil {
+					return
+				}
+			}
+			conn.ReadMessage() // until the client closes the connection
+		}
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/ws", serve(first))
+	mux.Handle("/reconnect", serve(second))
+	return httptest.NewServer(mux)
+}
+
+func TestClient_Run(t *testing.T) {
+	server := standIn(t, []string{
+		welcome("first"),
+		notification("1", "stream.online", `{"broadcaster_user_login":"xqc","started_at":"2023-03-01T12:00:00Z"}`),
+		notification("1", "stream.online", `{"broadcaster_user_login":"xqc","started_at":"2023-03-01T12:00:00Z"}`),
+		notification("2", "channel.ban", `{"broadcaster_user_login":"xqc","user_id":"1","is_permanent":true}`),
+		notification("3", "channel.ban", `{"broadcaster_user_login":"xqc","user_id":"488987844","reason":"spam","is_permanent":false,"ends_at":"2023-03-01T12:10:00Z"}`),
+		notification("4", "channel.chat.notification", `{"broadcaster_user_login":"forsen","chatter_user_id":"1","notice_type":"sub_gift","sub_gift":{"recipient_user_id":"488987844"}}`),
+		`{"metadata":{"message_id":"5","message_type":"session_keepalive"},"payload":{}}`,
+		`{"metadata":{"message_id":"6","message_type":"session_reconnect"},"payload":{"session":{"id":"first","reconnect_url":"RECONNECT_URL"}}}`,
+	}, []string{
+		welcome("second"),
+		notification("7", "stream.offline", `{"broadcaster_user_login":"xqc"}`),
+		notification("8", "channel.chat.clear", `{"broadcaster_user_login":"forsen"}`),
+	})
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	bus := eventbus.New()
+	events := make(chan any, 10)
+	eventbus.Subscribe(bus, func(e eventbus.StreamOnline) { events <- e })
+	eventbus.Subscribe(bus, func(e eventbus.StreamOffline) { events <- e })
+	eventbus.Subscribe(bus, func(e eventbus.ChatCleared) { events <- e })
+	eventbus.Subscribe(bus, func(e eventbus.EmotesChanged) { events <- e })
+
+	api := &fakeSubscriber{}
+	subscriptions := Subscriptions("71092938", "488987844")
+	c := NewClient(wsURL+"/ws", api, map[string]string{"xqc": "71092938"}, "488987844", bus, clock.NewVirtual(time.Now()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go c.Run(ctx)
+
+	want := []any{
+		eventbus.StreamOnline{Channel: "xqc", StartedAt: time.Date(2023, 3, 1, 12, 0, 0, 0, time.UTC)},
+		eventbus.EmotesChanged{Channel: "forsen"},
+		eventbus.StreamOffline{Channel: "xqc"},
+		eventbus.ChatCleared{Channel: "forsen"},
+	}
+	for i, w := range want {
+		select {
+		case got := <-events:
+			if !reflect.DeepEqual(got, w) {
+				t.Errorf("event %d = %+v, want %+v", i, got, w)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for event %d", i)
+		}
+	}
+
+	api.lock.Lock()
+	defer api.lock.Unlock()
+	if len(api.sessions) != len(subscriptions) {
+		t.Errorf("created %d subscriptions, want %d once", len(api.sessions), len(subscriptions))
+	}
+	for _, s := range api.sessions {
+		if s != "first" {
+			t.Errorf("subscribed for session %q, want first", s)
+		}
+	}
+}
+
+func TestClient_AddAndRemoveChannel(t *testing.T) {
+	server := standIn(t, []string{welcome("first")}, nil)
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	api := &fakeSubscriber{}
+	c := NewClient(wsURL+"/ws", api, map[string]string{"xqc": "71092938"}, "488987844", eventbus.New(), clock.NewVirtual(time.Now()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.AddChannel(ctx, "forsen", "22484632") // before the session, subscribed once it's welcomed
+	go c.Run(ctx)
+
+	perChannel := len(Subscriptions("", ""))
+	deadline := time.Now().Add(5 * time.Second)
+	for !reflect.DeepEqual(api.channels(), map[string]int{"71092938": perChannel, "22484632": perChannel}) {
+		if time.Now().After(deadline) {
+			t.Fatalf("subscriptions = %v, want %d in both channels", api.channels(), perChannel)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	c.AddChannel(ctx, "pokimane", "44445592")
+	c.AddChannel(ctx, "pokimane", "44445592")
+	c.RemoveChannel(ctx, "xqc")
+	want := map[string]int{"22484632": perChannel, "44445592": perChannel}
+	if got := api.channels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("subscriptions = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,28 @@
+// Package feature is how behaviors plug into the bot.
+//
+// A feature is anything implementing Feature. It handles events by also implementing
+// MessageHandler, RoomStateHandler, ClearChatHandler, EmoteRefreshHandler or TickHandler,
+// and reads its section of the features in env.json by implementing Configurable.
+// Features are only given the events of channels they're enabled in, see Settings.
+//
+// Features living outside package main register a Factory from an init function:
+//
+//	func init() {
+//		feature.Register("greeter", func(host feature.Host) feature.Feature {
+//			return &greeter{host: host}
+//		})
+//	}
+//
+// and are compiled into the bot by blank importing their package from a file in package main,
+// usually behind a build tag so they're opt-in:
+//
+//	//go:build greeter
+//
+//	package main
+//
+//	import _ "example.com/greeter"
+//
+// The Host a factory gets lets the feature talk in chat, look up emotes and inspect the message
+// queues of the channels. Handlers run on the goroutine reading from chat, so anything slow,
+// like waiting before a reply, belongs in a goroutine of its own that stops once Host.Context is done.
+package feature
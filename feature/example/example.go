@@ -0,0 +1,67 @@
+// Package example is a minimal feature to copy from, compiled into the bot with -tags example.
+// It answers a trigger message, by default "!harubot", at most once per cooldown per channel.
+package example
+
+import (
+	"encoding/json"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"harubot/feature"
+	"strings"
+	"sync"
+	"time"
+)
+
+const Name = "example"
+
+func init() {
+	feature.Register(Name, New)
+}
+
+type Config struct {
+	Trigger         string `json:"trigger"`
+	Response        string `json:"response"`
+	CooldownSeconds int    `json:"cooldown-seconds"`
+}
+
+type Example struct {
+	host         feature.Host
+	config       Config
+	lastResponse map[string]time.Time
+	lock         sync.Mutex
+}
+
+func New(host feature.Host) feature.Feature {
+	return &Example{
+		host: host,
+		config: Config{
+			Trigger:         "!harubot",
+			Response:        "MrDestructoid",
+			CooldownSeconds: 60,
+		},
+		lastResponse: map[string]time.Time{},
+	}
+}
+
+func (e *Example) Name() string {
+	return Name
+}
+
+func (e *Example) Configure(config json.RawMessage) error {
+	return json.Unmarshal(config, &e.config)
+}
+
+func (e *Example) OnMessage(m twitchirc.PrivateMessage) {
+	if !strings.EqualFold(strings.TrimSpace(m.Message), e.config.Trigger) || strings.EqualFold(m.User.Name, e.host.SelfUsername()) {
+		return
+	}
+	now := e.host.Clock().Now()
+	e.lock.Lock()
+	last, responded := e.lastResponse[m.Channel]
+	if responded && now.Sub(last) < time.Duration(e.config.CooldownSeconds)*time.Second {
+		e.lock.Unlock()
+		return
+	}
+	e.lastResponse[m.Channel] = now
+	e.lock.Unlock()
+	e.host.Say(m.Channel, e.config.Response)
+}
@@ -0,0 +1,248 @@
+package feature
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"harubot/clock"
+	"sync"
+	"time"
+)
+
+// Kinds of events features can handle
+const (
+	EventMessage      = "message"
+	EventRoomState    = "roomstate"
+	EventClearChat    = "clearchat"
+	EventEmoteRefresh = "emote-refresh"
+	EventTick         = "tick"
+)
+
+// Feature is one behavior of the bot. It handles events by also implementing any of the handler interfaces below.
+type Feature interface {
+	Name() string
+}
+
+type MessageHandler interface {
+	OnMessage(m twitchirc.PrivateMessage)
+}
+
+type RoomStateHandler interface {
+	OnRoomState(m twitchirc.RoomStateMessage)
+}
+
+type ClearChatHandler interface {
+	OnClearChat(m twitchirc.ClearChatMessage)
+}
+
+// EmoteRefreshHandler is called after the emote cache was refetched
+type EmoteRefreshHandler interface {
+	OnEmoteRefresh()
+}
+
+// TickHandler is called every tick interval, regardless of channel
+type TickHandler interface {
+	OnTick(now time.Time)
+}
+
+// Configurable features read their config section from the settings they're registered with
+type Configurable interface {
+	Configure(config json.RawMessage) error
+}
+
+// Settings are the config section of a feature
+type Settings struct {
+	Enabled  *bool           `json:"enabled"`  // defaults to true
+	Channels map[string]bool `json:"channels"` // enables or disables the feature in single channels, overriding Enabled
+	Config   json.RawMessage `json:"config"`   // passed to Configure, left out to keep the feature's defaults
+}
+
+// Metrics count what a feature did since the bot started
+type Metrics struct {
+	Events   map[string]int64 `json:"events"`   // handled events by kind
+	Disabled int64            `json:"disabled"` // events skipped because the feature is disabled in their channel
+	Time     time.Duration    `json:"time"`     // spent handling events
+}
+
+// Status is a feature with its settings and metrics
+type Status struct {
+	Name     string          `json:"name"`
+	Enabled  bool            `json:"enabled"`
+	Channels map[string]bool `json:"channels"`
+	Metrics  Metrics         `json:"metrics"`
+}
+
+type registered struct {
+	feature  Feature
+	enabled  bool
+	channels map[string]bool
+	metrics  Metrics
+	lock     sync.Mutex // guards metrics
+}
+
+func (r *registered) enabledIn(channel string) bool {
+	if enabled, ok := r.channels[channel]; ok {
+		return enabled
+	}
+	return r.enabled
+}
+
+// Registry holds the features of the bot and passes events to them in the order they were registered
+type Registry struct {
+	features []*registered
+	byName   map[string]*registered
+	clock    clock.Clock
+	lock     sync.RWMutex
+}
+
+func NewRegistry(clk clock.Clock) *Registry {
+	return &Registry{
+		byName: map[string]*registered{},
+		clock:  clk,
+	}
+}
+
+// Register adds f, configuring it first if it's Configurable and settings have a config
+func (r *Registry) Register(f Feature, settings Settings) error {
+	if c, ok := f.(Configurable); ok && len(settings.Config) > 0 {
+		err := c.Configure(settings.Config)
+		if err != nil {
+			return fmt.Errorf("failed to configure feature %s: %s", f.Name(), err)
+		}
+	}
+	enabled := settings.Enabled == nil || *settings.Enabled
+	channels := map[string]bool{}
+	for channel, channelEnabled := range settings.Channels {
+		channels[channel] = channelEnabled
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, exists := r.byName[f.Name()]; exists {
+		return fmt.Errorf("feature %s is already registered", f.Name())
+	}
+	reg := &registered{
+		feature:  f,
+		enabled:  enabled,
+		channels: channels,
+		metrics:  Metrics{Events: map[string]int64{}},
+	}
+	r.features = append(r.features, reg)
+	r.byName[f.Name()] = reg
+	return nil
+}
+
+// Enabled tells whether the feature called name handles events in channel
+func (r *Registry) Enabled(name string, channel string) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	reg, ok := r.byName[name]
+	return ok && reg.enabledIn(channel)
+}
+
+// Statuses returns every feature in the order they were registered
+func (r *Registry) Statuses() []Status {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	statuses := make([]Status, 0, len(r.features))
+	for _, reg := range r.features {
+		reg.lock.Lock()
+		events := map[string]int64{}
+		for kind, count := range reg.metrics.Events {
+			events[kind] = count
+		}
+		metrics := reg.metrics
+		metrics.Events = events
+		reg.lock.Unlock()
+		statuses = append(statuses, Status{
+			Name:     reg.feature.Name(),
+			Enabled:  reg.enabled,
+			Channels: reg.channels,
+			Metrics:  metrics,
+		})
+	}
+	return statuses
+}
+
+// dispatch calls handle with every feature enabled in channel, an empty channel meaning all of them.
+// handle reports whether the feature handles this kind of event at all.
+func (r *Registry) dispatch(kind string, channel string, handle func(f Feature) bool) {
+	r.lock.RLock()
+	features := r.features
+	r.lock.RUnlock()
+	for _, reg := range features {
+		if channel != "" && !reg.enabledIn(channel) {
+			reg.lock.Lock()
+			reg.metrics.Disabled++
+			reg.lock.Unlock()
+			continue
+		}
+		started := time.Now() // real time, a virtual clock doesn't pass while handling
+		if !handle(reg.feature) {
+			continue
+		}
+		elapsed := time.Since(started)
+		reg.lock.Lock()
+		reg.metrics.Events[kind]++
+		reg.metrics.Time += elapsed
+		reg.lock.Unlock()
+	}
+}
+
+func (r *Registry) Message(m twitchirc.PrivateMessage) {
+	r.dispatch(EventMessage, m.Channel, func(f Feature) bool {
+		h, ok := f.(MessageHandler)
+		if ok {
+			h.OnMessage(m)
+		}
+		return ok
+	})
+}
+
+func (r *Registry) RoomState(m twitchirc.RoomStateMessage) {
+	r.dispatch(EventRoomState, m.Channel, func(f Feature) bool {
+		h, ok := f.(RoomStateHandler)
+		if ok {
+			h.OnRoomState(m)
+		}
+		return ok
+	})
+}
+
+func (r *Registry) ClearChat(m twitchirc.ClearChatMessage) {
+	r.dispatch(EventClearChat, m.Channel, func(f Feature) bool {
+		h, ok := f.(ClearChatHandler)
+		if ok {
+			h.OnClearChat(m)
+		}
+		return ok
+	})
+}
+
+func (r *Registry) EmoteRefresh() {
+	r.dispatch(EventEmoteRefresh, "", func(f Feature) bool {
+		h, ok := f.(EmoteRefreshHandler)
+		if ok {
+			h.OnEmoteRefresh()
+		}
+		return ok
+	})
+}
+
+func (r *Registry) Tick(now time.Time) {
+	r.dispatch(EventTick, "", func(f Feature) bool {
+		h, ok := f.(TickHandler)
+		if ok {
+			h.OnTick(now)
+		}
+		return ok
+	})
+}
+
+// RoutinelyTick sends a tick to the features every interval
+func (r *Registry) RoutinelyTick(ctx context.Context, interval time.Duration) {
+	for clock.SleepContext(ctx, r.clock, interval) == nil {
+		r.Tick(r.clock.Now())
+	}
+}
@@ -0,0 +1,100 @@
+package feature
+
+import (
+	"encoding/json"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"harubot/clock"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeFeature struct {
+	name    string
+	handled *[]string
+	prefix  string
+}
+
+func (f *fakeFeature) Name() string {
+	return f.name
+}
+
+func (f *fakeFeature) OnMessage(m twitchirc.PrivateMessage) {
+	*f.handled = append(*f.handled, f.prefix+f.name+" "+m.Channel)
+}
+
+func (f *fakeFeature) Configure(config json.RawMessage) error {
+	return json.Unmarshal(config, &f.prefix)
+}
+
+type tickOnly struct {
+	ticks int
+}
+
+func (f *tickOnly) Name() string {
+	return "ticker"
+}
+
+func (f *tickOnly) OnTick(now time.Time) {
+	f.ticks++
+}
+
+func TestRegistry(t *testing.T) {
+	disabled := false
+	handled := []string{}
+	r := NewRegistry(clock.NewVirtual(time.Now()))
+	ticker := &tickOnly{}
+	registrations := []struct {
+		feature  Feature
+		settings Settings
+	}{
+		{&fakeFeature{name: "first", handled: &handled}, Settings{Channels: map[string]bool{"forsen": false}}},
+		{ticker, Settings{}},
+		{&fakeFeature{name: "second", handled: &handled}, Settings{Enabled: &disabled, Channels: map[string]bool{"forsen": true}, Config: json.RawMessage(`"configured "`)}},
+	}
+	for _, reg := range registrations {
+		err := r.Register(reg.feature, reg.settings)
+		if err != nil {
+			t.Fatalf("Register() failed: %s", err)
+		}
+	}
+	if r.Register(&fakeFeature{name: "first"}, Settings{}) == nil {
+		t.Errorf("Register() of a duplicate name succeeded")
+	}
+
+	r.Message(twitchirc.PrivateMessage{Channel: "xqc"})
+	r.Message(twitchirc.PrivateMessage{Channel: "forsen"})
+	r.Tick(time.Now())
+
+	want := []string{"first xqc", "configured second forsen"}
+	if !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled %v, want %v", handled, want)
+	}
+	if ticker.ticks != 1 {
+		t.Errorf("ticked %d times, want 1", ticker.ticks)
+	}
+	statuses := r.Statuses()
+	if got := statuses[0].Metrics; got.Events[EventMessage] != 1 || got.Disabled != 1 {
+		t.Errorf("metrics of first = %+v, want 1 message and 1 disabled", got)
+	}
+	if got := statuses[1].Metrics.Events; len(got) != 1 || got[EventTick] != 1 {
+		t.Errorf("events of ticker = %v, want only 1 tick", got)
+	}
+	if r.Enabled("second", "xqc") || !r.Enabled("second", "forsen") {
+		t.Errorf("Enabled() of second doesn't follow its channel overrides")
+	}
+}
+
+func TestRegister(t *testing.T) {
+	Register("plugin", func(host Host) Feature { return &tickOnly{} })
+	plugins := Plugins()
+	if len(plugins) != 1 || plugins[0].Name != "plugin" {
+		t.Fatalf("Plugins() = %+v, want the registered plugin", plugins)
+	}
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Register() of a taken name didn't panic")
+		}
+	}()
+	Register("plugin", func(host Host) Feature { return &tickOnly{} })
+}
@@ -0,0 +1,71 @@
+package feature
+
+import (
+	"context"
+	"fmt"
+	"harubot/clock"
+	messagequeue "harubot/message-queue"
+	"sync"
+)
+
+// EmoteCache is the part of the emote cache features can look at
+type EmoteCache interface {
+	// Emotes returns the global emotes and those of channel, sorted
+	Emotes(channel string) (global []string, channelEmotes []string, err error)
+	IsWordAnEmoteInChannel(word string, channel string) bool
+	// MessageWithOnlyEmotes drops every word of message that isn't an emote in channel
+	MessageWithOnlyEmotes(message string, channel string) string
+	MessageContainsOnlyEmotes(message string, channel string) bool
+}
+
+// Host is what the bot offers the features created by a Factory
+type Host interface {
+	// Say sends message to channel, unless the rate limit, the chat modes or a pause keep the bot quiet there.
+	// Skipped messages show up in the decision log.
+	Say(channel string, message string)
+	Emotes() EmoteCache
+	// MessageQueue returns the recent messages of channel, if the bot is in it. Lock it while using it.
+	MessageQueue(channel string) (*messagequeue.MessageQueue, bool)
+	// Channels returns the channels the bot is in, sorted
+	Channels() []string
+	Paused(channel string) bool
+	SelfUsername() string
+	Clock() clock.Clock
+	// Context is cancelled once the bot starts shutting down
+	Context() context.Context
+}
+
+// Factory creates a feature for the bot behind host
+type Factory func(host Host) Feature
+
+// Plugin is a feature registered with Register
+type Plugin struct {
+	Name string
+	New  Factory
+}
+
+var (
+	plugins     = []Plugin{}
+	pluginsLock sync.Mutex
+)
+
+// Register makes a feature available to the bot, usually from an init function.
+// name is also the section of the feature in the features of env.json and has to be the Name of the features factory creates.
+// Register panics if name is already taken, like the names of two features compiled in by accident would clash.
+func Register(name string, factory Factory) {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	for _, p := range plugins {
+		if p.Name == name {
+			panic(fmt.Sprintf("feature %s is registered twice", name))
+		}
+	}
+	plugins = append(plugins, Plugin{Name: name, New: factory})
+}
+
+// Plugins returns the features registered with Register in the order they were registered
+func Plugins() []Plugin {
+	pluginsLock.Lock()
+	defer pluginsLock.Unlock()
+	return append([]Plugin{}, plugins...)
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"harubot/clock"
+	decisionlog "harubot/decision-log"
+	"harubot/feature"
+	"harubot/mention"
+	messagequeue "harubot/message-queue"
+	replystrategy "harubot/reply-strategy"
+	"harubot/scripting"
+	"strings"
+	"time"
+)
+
+const featureTickInterval = time.Second
+
+// pyramids builds the pyramids the bot's own account asks for with !pyramid <message> <size> <delay in ms>
+type pyramids struct {
+	state *state
+}
+
+func (f *pyramids) Name() string {
+	return "pyramids"
+}
+
+func (f *pyramids) OnMessage(m twitchirc.PrivateMessage) {
+	f.state.makePyramids(m)
+}
+
+type autoReplyConfig struct {
+	CooldownSeconds      int                             `json:"cooldown-seconds"` // per user
+	MaxEmotes            int                             `json:"max-emotes"`
+	ThreadedReplies      bool                            `json:"threaded-replies"` // instead of mentioning the user
+	Aliases              []string                        `json:"aliases"`          // nicknames that count as mentioning the bot
+	replystrategy.Config                                 // in channels without a strategy of their own
+	ChannelStrategies    map[string]replystrategy.Config `json:"channel-strategies"`
+}
+
+func (c autoReplyConfig) strategy(channel string) replystrategy.Config {
+	if strategy, ok := c.ChannelStrategies[channel]; ok {
+		return strategy
+	}
+	return c.Config
+}
+
+// autoReply answers mentions of the bot, by default with the emotes they contained
+type autoReply struct {
+	state      *state
+	config     autoReplyConfig
+	popularity *replystrategy.Popularity
+	mentions   *mention.Detector
+}
+
+func newAutoReply(state *state) *autoReply {
+	f := &autoReply{
+		state:      state,
+		config:     autoReplyConfig{CooldownSeconds: 30, MaxEmotes: 24},
+		popularity: replystrategy.NewPopularity(),
+	}
+	f.mentions = mention.NewDetector(state.selfUsername, state.selfDisplayname, nil)
+	return f
+}
+
+func (f *autoReply) Name() string {
+	return "autoreply"
+}
+
+func (f *autoReply) Configure(config json.RawMessage) error {
+	err := json.Unmarshal(config, &f.config)
+	if err != nil {
+		return err
+	}
+	err = f.config.Validate()
+	if err != nil {
+		return err
+	}
+	for channel, strategy := range f.config.ChannelStrategies {
+		err = strategy.Validate()
+		if err != nil {
+			return fmt.Errorf("strategy of %s: %s", channel, err)
+		}
+	}
+	f.mentions = mention.NewDetector(f.state.selfUsername, f.state.selfDisplayname, f.config.Aliases)
+	return nil
+}
+
+func (f *autoReply) OnMessage(m twitchirc.PrivateMessage) {
+	if !strings.EqualFold(m.User.Name, f.state.selfUsername) {
+		f.popularity.Observe(m.Channel, strings.Fields(f.state.emoteCache.MessageWithOnlyEmotes(m.Message, m.Channel)))
+	}
+	f.state.autoReply(m, f)
+}
+
+type echoConfig struct {
+	MaxWords int `json:"max-words"` // longer messages aren't queued at all
+}
+
+// echo joins in when chat spams the same sentence
+type echo struct {
+	state  *state
+	config echoConfig
+}
+
+func (f *echo) Name() string {
+	return "echo"
+}
+
+func (f *echo) Configure(config json.RawMessage) error {
+	return json.Unmarshal(config, &f.config)
+}
+
+func (f *echo) OnMessage(m twitchirc.PrivateMessage) {
+	f.state.spamBot(m, f.config)
+}
+
+// registerFeatures registers the built-in features and then those compiled in, with their settings from env.json, in the order they handle events
+func (state *state) registerFeatures(settings map[string]feature.Settings) error {
+	host := &featureHost{state: state}
+	features := []feature.Feature{
+		&pyramids{state: state},
+		newAutoReply(state),
+		&echo{state: state, config: echoConfig{MaxWords: 5}},
+		scripting.New(host),
+	}
+	for _, p := range feature.Plugins() {
+		f := p.New(host)
+		if f.Name() != p.Name {
+			return fmt.Errorf("feature registered as %s is called %s", p.Name, f.Name())
+		}
+		features = append(features, f)
+	}
+	known := map[string]bool{}
+	for _, f := range features {
+		known[f.Name()] = true
+		err := state.features.Register(f, settings[f.Name()])
+		if err != nil {
+			return err
+		}
+	}
+	err := checkSelfMessage(settings[selfMessage])
+	if err != nil {
+		return err
+	}
+	for name := range settings {
+		if name == selfMessage {
+			continue
+		}
+		if !known[name] {
+			return fmt.Errorf("unknown feature %s", name)
+		}
+	}
+	return nil
+}
+
+// selfMessage was a feature before the bot counted its own messages towards the rate limit in onPrivateMessage, so its section is still accepted
+const selfMessage = "self-message"
+
+// checkSelfMessage refuses settings that would turn off counting the bot's own messages, which keeps it under the rate limit
+func checkSelfMessage(settings feature.Settings) error {
+	if settings.Enabled != nil && !*settings.Enabled {
+		return fmt.Errorf("%s can't be disabled, it keeps the bot under the rate limit", selfMessage)
+	}
+	for channel, enabled := range settings.Channels {
+		if !enabled {
+			return fmt.Errorf("%s can't be disabled in %s, it keeps the bot under the rate limit", selfMessage, channel)
+		}
+	}
+	return nil
+}
+
+// featureHost is the bot as features registered outside package main see it
+type featureHost struct {
+	state *state
+}
+
+func (h *featureHost) Say(channel string, message string) {
+	if h.state.isPaused(channel) {
+		h.state.decide(channel, decisionlog.ActionSkip, message, "paused")
+		return
+	}
+	h.state.say(channel, message)
+}
+
+func (h *featureHost) Emotes() feature.EmoteCache {
+	return h.state.emoteCache
+}
+
+func (h *featureHost) MessageQueue(channel string) (*messagequeue.MessageQueue, bool) {
+	return h.state.messageQueue(channel)
+}
+
+func (h *featureHost) Channels() []string {
+	return h.state.channels()
+}
+
+func (h *featureHost) Paused(channel string) bool {
+	return h.state.isPaused(channel)
+}
+
+func (h *featureHost) SelfUsername() string {
+	return h.state.selfUsername
+}
+
+func (h *featureHost) Clock() clock.Clock {
+	return h.state.clock
+}
+
+func (h *featureHost) Context() context.Context {
+	return h.state.ctx
+}
@@ -5,12 +5,14 @@ go 1.18
 require (
 	github.com/forPelevin/gomoji v1.1.4
 	github.com/gempir/go-twitch-irc/v2 v2.8.1
+	github.com/gorilla/websocket v1.5.0
 	github.com/nicklaw5/helix/v2 v2.4.0
 	github.com/sirupsen/logrus v1.8.1
+	go.starlark.net v0.0.0-20230302034142-4b1e35fe2254
 )
 
 require (
 	github.com/golang-jwt/jwt/v4 v4.0.0 // indirect
 	github.com/rivo/uniseg v0.2.0 // indirect
-	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a // indirect
+	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
 )
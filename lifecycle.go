@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	log "github.com/sirupsen/logrus"
+	atomicfile "harubot/atomic-file"
+	"harubot/pause"
+	"time"
+)
+
+// outboundDrainDelay gives the IRC client time to write out queued messages and PARTs before disconnecting
+const outboundDrainDelay = time.Second
+
+// persistedState is what survives a restart of the bot
+type persistedState struct {
+	AutoReplyTimes map[string]time.Time   `json:"auto-reply-times"`
+	Pauses         map[string]pause.Pause `json:"pauses"`
+}
+
+// persist replaces the file at path with the current state, whole or not at all
+func (state *state) persist(path string) error {
+	state.persistLock.Lock()
+	defer state.persistLock.Unlock()
+	state.autoReplyTimesLock.Lock()
+	ps := persistedState{
+		AutoReplyTimes: state.autoReplyTimes,
+		Pauses:         state.pauses.All(),
+	}
+	bytes, err := json.Marshal(&ps)
+	state.autoReplyTimesLock.Unlock()
+	if err != nil {
+		return err
+	}
+	return atomicfile.WriteFile(path, bytes, 0644)
+}
+
+func (state *state) restore(path string) error {
+	ps := persistedState{}
+	err := readJSON(path, &ps)
+	if err != nil {
+		return err
+	}
+	state.pauses.Restore(ps.Pauses)
+	state.autoReplyTimesLock.Lock()
+	defer state.autoReplyTimesLock.Unlock()
+	for user, t := range ps.AutoReplyTimes {
+		state.autoReplyTimes[user] = t
+	}
+	return nil
+}
+
+// shutdown flushes pending messages, saves state and leaves all channels, skipping whatever doesn't finish before deadline
+func (state *state) shutdown(client *twitchirc.Client, e *environmentVariables, deadline time.Time) {
+	flushed := make(chan struct{})
+	go func() {
+		state.outgoing.Wait()
+		close(flushed)
+	}()
+	select {
+	case <-flushed:
+	case <-time.After(time.Until(deadline)):
+		log.Warn("gave up flushing pending messages")
+	}
+
+	if e.StatePath != "" {
+		err := state.persist(e.StatePath)
+		if err != nil {
+			log.Errorf("failed to persist state: %s", err)
+		}
+	}
+	if state.chatLog != nil {
+		err := state.chatLog.Close()
+		if err != nil {
+			log.Errorf("failed to close chat log: %s", err)
+		}
+	}
+
+	for _, channel := range state.channels() { // not e.Channels, channels are joined and parted at runtime
+		client.Depart(channel)
+	}
+	time.Sleep(outboundDrainDelay)
+	err := client.Disconnect()
+	if err != nil {
+		log.Errorf("failed to disconnect: %s", err)
+	}
+}
@@ -1,93 +1,263 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	twitchirc "github.com/gempir/go-twitch-irc/v2"
 	log "github.com/sirupsen/logrus"
+	"harubot/admin"
+	chatlog "harubot/chat-log"
+	"harubot/clock"
 	colorstate "harubot/color-state"
+	"harubot/commands"
+	"harubot/credentials"
+	decisionlog "harubot/decision-log"
 	"harubot/emotes"
+	eventbus "harubot/event-bus"
+	"harubot/eventsub"
+	"harubot/feature"
+	"harubot/mention"
 	messagequeue "harubot/message-queue"
+	"harubot/moderation"
+	"harubot/pause"
 	personalmessagequeue "harubot/personal-message-queue"
 	renewusertoken "harubot/renew-user-token"
+	replystrategy "harubot/reply-strategy"
+	roomstate "harubot/room-state"
+	streamstatus "harubot/stream-status"
+	"harubot/supervisor"
+	twitchapi "harubot/twitch-api"
+	userstate "harubot/user-state"
 	"io/ioutil"
 	"math/rand"
+	"os"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
-type Secrets struct {
-	Username           string `json:"username"`
-	OauthKey           string `json:"oauth-key"`
-	TwitchAccessToken  string `json:"twitch-access-token"`
-	TwitchClientID     string `json:"twitch-client-id"`
-	TwitchClientSecret string `json:"twitch-client-secret"`
-	TwitchRefreshToken string `json:"twitch-refresh-token"`
+type environmentVariables struct {
+	MinimumChatVelocity              float64                     `json:"minimum-chat-velocity"`
+	Channels                         []string                    `json:"channels"`
+	SelfUsername                     string                      `json:"self-username"`
+	SelfDisplayname                  string                      `json:"self-displayname"`
+	SelfUserId                       string                      `json:"self-user-id"`
+	EmoteCacheRefreshIntervalMinutes int                         `json:"emote-cache-refresh-interval-minutes"`
+	Colors                           []string                    `json:"colors"`
+	ColorMode                        string                      `json:"color-mode"`
+	ColorGradientSteps               int                         `json:"color-gradient-steps"`
+	ColorIntervalSeconds             int                         `json:"color-interval-seconds"`
+	ColorBeforeMessage               bool                        `json:"color-before-message"`
+	ColorSchedule                    []colorstate.ScheduleEntry  `json:"color-schedule"`
+	PersonalMessageQueueCapacity     int                         `json:"personal-message-queue-capacity"`
+	TokenRefreshIntervalHours        int                         `json:"token-refresh-interval-hours"`
+	TokenEndpoint                    string                      `json:"token-endpoint"`
+	TokenValidateEndpoint            string                      `json:"token-validate-endpoint"`
+	ChatLogDirectory                 string                      `json:"chat-log-directory"`
+	ChatLogMaxFileMegabytes          int                         `json:"chat-log-max-file-megabytes"`
+	HelixAPIURL                      string                      `json:"helix-api-url"`
+	ColorIRCFallback                 bool                        `json:"color-irc-fallback"`
+	EmoteSnapshotPath                string                      `json:"emote-snapshot-path"`
+	StatePath                        string                      `json:"state-path"`
+	ShutdownTimeoutSeconds           int                         `json:"shutdown-timeout-seconds"`
+	Owners                           []string                    `json:"owners"`
+	CommandPrefix                    string                      `json:"command-prefix"`
+	AdminAddress                     string                      `json:"admin-address"`
+	AdminToken                       string                      `json:"admin-token"`
+	EchoAsModerator                  bool                        `json:"echo-as-moderator"`
+	StreamStatusIntervalSeconds      int                         `json:"stream-status-interval-seconds"`
+	OfflinePolicy                    string                      `json:"offline-policy"`
+	OfflinePolicies                  map[string]string           `json:"offline-policies"`
+	EventSubEnabled                  bool                        `json:"eventsub-enabled"`
+	EventSubURL                      string                      `json:"eventsub-url"`
+	Features                         map[string]feature.Settings `json:"features"`
 }
 
-type environmentVariables struct {
-	MinimumChatVelocity              float64  `json:"minimum-chat-velocity"`
-	Channels                         []string `json:"channels"`
-	SelfUsername                     string   `json:"self-username"`
-	SelfDisplayname                  string   `json:"self-displayname"`
-	SelfUserId                       string   `json:"self-user-id"`
-	EmoteCacheRefreshIntervalMinutes int      `json:"emote-cache-refresh-interval-minutes"`
-	Colors                           []string `json:"colors"`
-	PersonalMessageQueueCapacity     int      `json:"personal-message-queue-capacity"`
-	TokenRefreshIntervalHours        int      `json:"token-refresh-interval-hours"`
-}
-
-func joinChannels(client *twitchirc.Client, channels []string) {
+// joinChannels joins channels one by one, skipping those isTracked says the bot has left again in the meantime
+func joinChannels(ctx context.Context, client *twitchirc.Client, channels []string, isTracked func(channel string) bool, clk clock.Clock) {
 	for _, c := range channels {
+		if !isTracked(c) {
+			continue
+		}
 		client.Join(c)
 		log.Printf("joined channel #%s", c)
-		time.Sleep(2 * time.Second) // avoid rate limits
+		if clock.SleepContext(ctx, clk, 2*time.Second) != nil { // avoid rate limits
+			return
+		}
 	}
 }
 
+// chatClient is the part of the IRC client the bot talks through, so the replay harness can stand in for it
+type chatClient interface {
+	Say(channel, message string)
+	Whisper(username, message string)
+	Join(channels ...string)
+	Depart(channel string)
+	Userlist(channel string) ([]string, error)
+}
+
+// chatReplier sends messages through Helix, which unlike IRC here can send threaded replies
+type chatReplier interface {
+	SendChatMessage(ctx context.Context, message twitchapi.ChatMessage) error
+}
+
 type state struct {
+	ctx                    context.Context // cancelled once the bot starts shutting down
 	messageQueuesByChannel map[string]*messagequeue.MessageQueue
+	channelsLock           sync.RWMutex // guards messageQueuesByChannel, which changes on join and part
 	personalMessageQueue   *personalmessagequeue.PersonalMessageQueue
-	client                 *twitchirc.Client
+	client                 chatClient
+	replier                chatReplier       // nil if replies can't be threaded, like when replaying
+	channelIDs             map[string]string // by login, from channel-ids.json
 	emoteCache             *emotes.Cache
 	colorState             *colorstate.ColorState
+	chatLog                *chatlog.Recorder
+	decisions              *decisionlog.Log
+	commands               *commands.Registry
+	features               *feature.Registry
+	owners                 map[string]bool
+	pauses                 *pause.Pauses
+	roomStates             *roomstate.Tracker
+	userStates             *userstate.Tracker
+	echoAsModerator        bool
+	streams                *streamstatus.Tracker // nil if stream status isn't polled
+	eventSub               *eventsub.Client      // nil if disabled
+	defaultOfflinePolicy   string
+	offlinePolicies        map[string]string
+	autoParted             map[string]bool // channels left because they went offline
+	autoPartedLock         sync.Mutex
+	moderation             *moderation.Monitor
+	clock                  clock.Clock
 	autoReplyTimes         map[string]time.Time
+	autoReplyTimesLock     sync.Mutex
+	statePath              string         // where state is persisted, empty if it isn't
+	persistLock            sync.Mutex     // keeps an older state from overwriting a newer one
+	outgoing               sync.WaitGroup // autoreplies waiting to be sent
+	pendingReplies         map[int]pendingReply
+	pendingRepliesLock     sync.Mutex
+	nextPendingReplyID     int
+	random                 *rand.Rand // picks autoreply delays and templates, seeded by replays to repeat them
+	randomLock             sync.Mutex
 	minimumChatVelocity    float64
 	selfUsername           string
 	selfDisplayname        string
+	selfUserId             string
 	connected              bool
 }
 
-func newState(s *Secrets, envVars *environmentVariables) *state {
-	emoteCache := emotes.NewCache(envVars.Channels, envVars.SelfUserId)
-	go emoteCache.RoutinelyRefreshCache(envVars.EmoteCacheRefreshIntervalMinutes)
-
-	client := twitchirc.NewClient(s.Username, s.OauthKey)
-	go joinChannels(client, envVars.Channels)
-
-	pmq := personalmessagequeue.NewPersonalMessageQueue(envVars.PersonalMessageQueueCapacity)
-
-	cs := colorstate.NewColorState(envVars.Colors)
-	go cs.RoutinelyChangeColor(client, envVars.SelfUsername, pmq)
-
-	mqs := messagequeue.NewMessageQueues(envVars.Channels)
-	autoReplyTimes := map[string]time.Time{}
-
-	go renewusertoken.RoutinelyRefreshToken(time.Duration(envVars.TokenRefreshIntervalHours) * time.Hour)
-
-	return &state{
+func newState(ctx context.Context, client chatClient, emoteCache *emotes.Cache, pmq *personalmessagequeue.PersonalMessageQueue, clk clock.Clock, envVars *environmentVariables) (*state, error) {
+	owners := map[string]bool{}
+	for _, owner := range envVars.Owners {
+		owners[strings.ToLower(owner)] = true
+	}
+	s := &state{
+		ctx:                    ctx,
+		clock:                  clk,
 		emoteCache:             emoteCache,
-		colorState:             cs,
 		client:                 client,
 		personalMessageQueue:   pmq,
-		autoReplyTimes:         autoReplyTimes,
-		messageQueuesByChannel: mqs,
+		autoReplyTimes:         map[string]time.Time{},
+		pendingReplies:         map[int]pendingReply{},
+		random:                 rand.New(rand.NewSource(time.Now().UnixNano())),
+		messageQueuesByChannel: messagequeue.NewMessageQueues(envVars.Channels),
+		decisions:              decisionlog.NewLog(decisionLogCapacity),
 		minimumChatVelocity:    envVars.MinimumChatVelocity,
 		selfUsername:           envVars.SelfUsername,
 		selfDisplayname:        envVars.SelfDisplayname,
+		owners:                 owners,
+		pauses:                 pause.NewPauses(clk),
+		roomStates:             roomstate.NewTracker(clk),
+		userStates:             userstate.NewTracker(),
+		echoAsModerator:        envVars.EchoAsModerator,
+		defaultOfflinePolicy:   envVars.OfflinePolicy,
+		offlinePolicies:        envVars.OfflinePolicies,
+		autoParted:             map[string]bool{},
+		moderation:             moderation.NewMonitor(envVars.SelfUsername, envVars.SelfUserId, clk),
+		features:               feature.NewRegistry(clk),
+		selfUserId:             envVars.SelfUserId,
 		connected:              false,
 	}
+	s.registerCommands(envVars.CommandPrefix)
+	err := s.registerFeatures(envVars.Features)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// messageQueue returns the queue of channel, if the bot is in it
+func (state *state) messageQueue(channel string) (*messagequeue.MessageQueue, bool) {
+	state.channelsLock.RLock()
+	defer state.channelsLock.RUnlock()
+	mq, joined := state.messageQueuesByChannel[channel]
+	return mq, joined
+}
+
+// addChannel starts tracking channel, caching its emotes and subscribing to its events, and reports whether it wasn't tracked yet
+func (state *state) addChannel(channel string) bool {
+	if !state.trackChannel(channel) {
+		return false
+	}
+	state.emoteCache.AddChannel(channel)
+	if state.eventSub != nil {
+		if id, ok := state.channelIDs[channel]; ok {
+			state.eventSub.AddChannel(state.ctx, channel, id)
+		} else {
+			log.Warnf("not subscribing to eventsub in #%s, it's missing from channel-ids.json", channel)
+		}
+	}
+	return true
+}
+
+// removeChannel stops tracking channel, caching its emotes and subscribing to its events, and reports whether it was tracked
+func (state *state) removeChannel(channel string) bool {
+	if !state.untrackChannel(channel) {
+		return false
+	}
+	state.emoteCache.RemoveChannel(channel)
+	if state.eventSub != nil {
+		state.eventSub.RemoveChannel(state.ctx, channel)
+	}
+	return true
+}
+
+// trackChannel starts tracking channel and reports whether it wasn't tracked yet
+func (state *state) trackChannel(channel string) bool {
+	state.channelsLock.Lock()
+	defer state.channelsLock.Unlock()
+	if _, joined := state.messageQueuesByChannel[channel]; joined {
+		return false
+	}
+	state.messageQueuesByChannel[channel] = messagequeue.NewMessageQueue()
+	return true
+}
+
+// untrackChannel stops tracking channel and reports whether it was tracked
+func (state *state) untrackChannel(channel string) bool {
+	state.channelsLock.Lock()
+	defer state.channelsLock.Unlock()
+	if _, joined := state.messageQueuesByChannel[channel]; !joined {
+		return false
+	}
+	delete(state.messageQueuesByChannel, channel)
+	return true
+}
+
+// channels returns the channels the bot is in, sorted
+func (state *state) channels() []string {
+	state.channelsLock.RLock()
+	defer state.channelsLock.RUnlock()
+	channels := make([]string, 0, len(state.messageQueuesByChannel))
+	for channel := range state.messageQueuesByChannel {
+		channels = append(channels, channel)
+	}
+	sort.Strings(channels)
+	return channels
 }
 
 func readJSON(path string, structure any) error {
@@ -102,9 +272,8 @@ func readJSON(path string, structure any) error {
 	return nil
 }
 
-func setup() {
-	s := &Secrets{}
-	err := readJSON("./secrets.json", s)
+func setup(secretsPath string) {
+	provider, err := credentials.Open(secretsPath)
 	if err != nil {
 		log.Fatalf("failed to read secrets: %s", err)
 	}
@@ -119,164 +288,591 @@ func setup() {
 		log.Fatalf("failed to read environment variables: %s", err)
 	}
 
-	state := newState(s, e)
+	clk := clock.New()
+	sup := supervisor.New()
+	ctx := sup.Context()
+
+	emoteCache := emotes.NewCache(e.Channels, e.SelfUserId, provider, clk)
+	sup.Go("emote-cache-refresh", func(ctx context.Context) {
+		emoteCache.RoutinelyRefreshCache(ctx, e.EmoteCacheRefreshIntervalMinutes, e.EmoteSnapshotPath)
+	})
 
-	state.client.OnReconnectMessage(func(m twitchirc.ReconnectMessage) {
+	s := provider.Get()
+	client := twitchirc.NewClient(s.Username, s.IRCToken())
+	provider.Subscribe(func(s credentials.Secrets) {
+		client.SetIRCToken(s.IRCToken()) // used from the next reconnect on, the current session stays logged in
+	})
+	pmq := personalmessagequeue.NewPersonalMessageQueue(e.PersonalMessageQueueCapacity, clk)
+	sup.Go("personal-velocity-log", pmq.RoutinelyLogVelocity)
+
+	api := twitchapi.NewClient(e.HelixAPIURL, provider)
+	var colorFallback colorstate.ColorSetter
+	if e.ColorIRCFallback {
+		colorFallback = &colorstate.IRCColorSetter{
+			Client:       client,
+			SelfUsername: e.SelfUsername,
+			PMQ:          pmq,
+			Clock:        clk,
+		}
+	}
+	colorEngine, err := colorstate.NewEngine(colorstate.Config{
+		Mode:          e.ColorMode,
+		Colors:        e.Colors,
+		GradientSteps: e.ColorGradientSteps,
+		Schedule:      e.ColorSchedule,
+	})
+	if err != nil {
+		log.Fatalf("failed to set up colors: %s", err)
+	}
+	colorInterval := time.Duration(e.ColorIntervalSeconds) * time.Second
+	cs := colorstate.NewColorState(colorEngine, colorInterval, e.ColorBeforeMessage, &colorstate.HelixColorSetter{API: api, UserID: e.SelfUserId}, colorFallback, clk)
+	sup.Go("color-change", cs.RoutinelyChangeColor)
+
+	refresher := renewusertoken.NewRefresher(renewusertoken.Config{
+		TokenURL:    e.TokenEndpoint,
+		ValidateURL: e.TokenValidateEndpoint,
+		MaxInterval: time.Duration(e.TokenRefreshIntervalHours) * time.Hour,
+	}, provider, clk)
+	sup.Go("token-refresh", refresher.RoutinelyRefreshToken)
+
+	state, err := newState(ctx, client, emoteCache, pmq, clk, e)
+	if err != nil {
+		log.Fatalf("failed to set up features: %s", err)
+	}
+	emoteCache.Subscribe(state.features.EmoteRefresh)
+	sup.Go("feature-ticks", func(ctx context.Context) {
+		state.features.RoutinelyTick(ctx, featureTickInterval)
+	})
+	state.colorState = cs
+	if canSendChatMessages(ctx, refresher, provider.Get().TwitchAccessToken) {
+		state.replier = api
+	}
+	state.channelIDs = c
+	state.statePath = e.StatePath
+	if e.StatePath != "" {
+		err = state.restore(e.StatePath)
+		if err != nil {
+			log.Errorf("failed to restore state: %s", err)
+		}
+	}
+	if e.ChatLogDirectory != "" {
+		recorder, err := chatlog.NewRecorder(e.ChatLogDirectory, int64(e.ChatLogMaxFileMegabytes)*1024*1024)
+		if err != nil {
+			log.Fatalf("failed to create chat log recorder: %s", err)
+		}
+		state.chatLog = recorder
+	}
+
+	sup.Go("join-channels", func(ctx context.Context) {
+		joinChannels(ctx, client, e.Channels, func(channel string) bool {
+			_, tracked := state.messageQueue(channel)
+			return tracked
+		}, clk)
+	})
+	if e.StreamStatusIntervalSeconds > 0 {
+		for _, policy := range append([]string{e.OfflinePolicy}, policies(e.OfflinePolicies)...) {
+			if !streamstatus.ValidPolicy(policy) {
+				log.Fatalf("unknown offline policy %q", policy)
+			}
+		}
+		state.streams = streamstatus.NewTracker(api, state.polledChannels, clk)
+		state.streams.Subscribe(state.onStreamStatusChange)
+		sup.Go("stream-status", func(ctx context.Context) {
+			state.streams.RoutinelyPoll(ctx, time.Duration(e.StreamStatusIntervalSeconds)*time.Second)
+		})
+	}
+	if e.EventSubEnabled {
+		bus := eventbus.New()
+		state.subscribeToEvents(bus)
+		state.eventSub = eventsub.NewClient(e.EventSubURL, api, eventSubChannelIDs(e.Channels, c), e.SelfUserId, bus, clk)
+		sup.Go("eventsub", state.eventSub.Run)
+	}
+	if e.AdminAddress != "" {
+		adminServer := admin.NewServer(&adminBot{state: state}, e.AdminToken)
+		sup.Go("admin-api", func(ctx context.Context) {
+			err := adminServer.Serve(ctx, e.AdminAddress)
+			if err != nil {
+				log.Errorf("admin api stopped: %s", err)
+			}
+		})
+	}
+
+	client.OnReconnectMessage(func(m twitchirc.ReconnectMessage) {
 		log.Println("received RECONNECT")
 	})
-	state.client.OnPingMessage(func(m twitchirc.PingMessage) {
+	client.OnPingMessage(func(m twitchirc.PingMessage) {
 		log.WithFields(log.Fields{
 			"message": m.Message,
 		}).Info("received PING")
 	})
-	state.client.OnPongMessage(func(m twitchirc.PongMessage) {
+	client.OnPongMessage(func(m twitchirc.PongMessage) {
 		log.WithFields(log.Fields{
 			"message": m.Message,
 		}).Info("received PONG")
 	})
-	state.client.OnNoticeMessage(func(m twitchirc.NoticeMessage) {
+	client.OnNoticeMessage(func(m twitchirc.NoticeMessage) {
 		log.WithFields(log.Fields{
 			"channel": m.Channel,
 			"message": m.Message,
 		}).Info("received NOTICE")
+		if m.Channel != "" {
+			state.record(m.Channel, m.Raw)
+		}
+		if m.MsgID == "turbo_only_color" {
+			cs.DisallowHexColors()
+		}
+		state.onNotice(m)
+	})
+	client.OnGlobalUserStateMessage(func(m twitchirc.GlobalUserStateMessage) {
+		cs.ObserveBadges(m.User.Badges)
+		state.userStates.UpdateGlobal(m.User.Badges)
+	})
+	client.OnUserStateMessage(func(m twitchirc.UserStateMessage) {
+		state.record(m.Channel, m.Raw)
+		cs.ObserveBadges(m.User.Badges)
+		state.userStates.Update(m.Channel, m.User.Badges)
 	})
 
-	state.client.OnConnect(func() {
+	client.OnConnect(func() {
 		if state.connected == true {
-			log.Fatalf("restarting because of reconnect")
+			sup.Stop(errors.New("restarting because of reconnect"))
+			return
 		}
 		log.Infoln("connected")
 		state.connected = true
 	})
 
-	state.client.OnPrivateMessage(func(m twitchirc.PrivateMessage) {
-		state.makePyramids(m)
-		state.autoReply(m)
-		state.onSelfMessage(m)
-		state.spamBot(m)
+	client.OnPrivateMessage(func(m twitchirc.PrivateMessage) {
+		state.record(m.Channel, m.Raw)
+		state.onPrivateMessage(m)
+	})
+	client.OnWhisperMessage(state.onWhisperMessage)
+	client.OnClearChatMessage(func(m twitchirc.ClearChatMessage) {
+		state.record(m.Channel, m.Raw)
+		state.onClearChat(m)
+	})
+	client.OnClearMessage(func(m twitchirc.ClearMessage) {
+		state.record(m.Channel, m.Raw)
+		state.onClearMessage(m)
+	})
+	client.OnRoomStateMessage(func(m twitchirc.RoomStateMessage) {
+		state.record(m.Channel, m.Raw)
+		state.roomStates.Update(m)
+		state.features.RoomState(m)
+	})
+	client.OnUserNoticeMessage(func(m twitchirc.UserNoticeMessage) {
+		state.record(m.Channel, m.Raw)
+	})
+	client.OnUserJoinMessage(func(m twitchirc.UserJoinMessage) {
+		state.record(m.Channel, m.Raw)
+	})
+	client.OnUserPartMessage(func(m twitchirc.UserPartMessage) {
+		state.record(m.Channel, m.Raw)
+	})
+
+	sup.Go("irc", func(ctx context.Context) {
+		err := client.Connect()
+		if err != nil && err != twitchirc.ErrClientDisconnected {
+			sup.Stop(fmt.Errorf("failed to connect: %s", err))
+		}
 	})
 
-	err = state.client.Connect()
-	if err != nil {
-		log.Fatalf("failed to connect: %s", err)
+	<-ctx.Done()
+	log.WithFields(log.Fields{
+		"reason": sup.Err(),
+	}).Info("shutting down")
+	deadline := time.Now().Add(time.Duration(e.ShutdownTimeoutSeconds) * time.Second)
+	state.shutdown(client, e, deadline)
+	if !sup.Wait(time.Until(deadline)) {
+		log.Warn("gave up waiting for background routines to stop")
+	}
+	if sup.Err() != nil {
+		log.Errorf("exiting because of error: %s", sup.Err())
+		os.Exit(1)
 	}
+	log.Info("shut down cleanly")
 }
 
 func main() {
-	setup()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			err := runReplay(os.Args[2:])
+			if err != nil {
+				log.Fatalf("failed to replay chat logs: %s", err)
+			}
+			return
+		case "auth":
+			err := runAuth(os.Args[2:])
+			if err != nil {
+				log.Fatalf("failed to authorize: %s", err)
+			}
+			return
+		case "tui":
+			err := runTUI(os.Args[2:])
+			if err != nil {
+				log.Fatalf("failed to run dashboard: %s", err)
+			}
+			return
+		case "encrypt-secrets":
+			err := runEncryptSecrets(os.Args[2:])
+			if err != nil {
+				log.Fatalf("failed to encrypt secrets: %s", err)
+			}
+			return
+		}
+	}
+	secretsPath := flag.String("secrets", "./secrets.json", "path to the secrets file, leave empty to read secrets only from environment variables")
+	flag.Parse()
+	setup(*secretsPath)
 }
 
+func (state *state) onPrivateMessage(m twitchirc.PrivateMessage) {
+	if _, joined := state.messageQueue(m.Channel); !joined {
+		return // left the channel, but messages can arrive until the PART goes through
+	}
+	isCommand := state.onCommand(m)
+	state.onSelfMessage(m) // not a feature, so the rate limit holds whatever is disabled
+	if isCommand || state.isQuietOffline(m.Channel) {
+		return
+	}
+	state.features.Message(m)
+}
+
+// decide adds something the bot did or skipped to the decision log
+func (state *state) decide(channel string, action string, message string, reason string) {
+	state.decisions.Add(decisionlog.Decision{
+		Time:    state.clock.Now(),
+		Channel: channel,
+		Action:  action,
+		Message: message,
+		Reason:  reason,
+	})
+}
+
+// record writes a raw incoming line to the chat log, if recording is enabled
+func (state *state) record(channel string, raw string) {
+	if state.chatLog == nil {
+		return
+	}
+	err := state.chatLog.Record(channel, state.clock.Now(), raw)
+	if err != nil {
+		log.Errorf("failed to record chat log line: %s", err)
+	}
+}
+
+// onSelfMessage counts the bot's own messages towards the rate limit, whoever sent them, and clears the queue of their channel
 func (state *state) onSelfMessage(m twitchirc.PrivateMessage) {
-	mq := state.messageQueuesByChannel[m.Channel]
+	mq, _ := state.messageQueue(m.Channel)
 	if strings.ToLower(m.User.Name) == state.selfUsername {
+		mq.Lock()
 		mq.Clear()
-		state.personalMessageQueue.Push(time.Now())
+		mq.Unlock()
+		state.personalMessageQueue.Push(state.clock.Now())
 	}
 }
 
 func (state *state) say(channel string, message string) {
-	if state.personalMessageQueue.Velocity() > 0.66 {
+	_, _ = state.deliver(channel, message, false, func(message string) error {
+		state.client.Say(channel, message)
+		return nil
+	})
+}
+
+// canSendChatMessages tells whether accessToken has the scope to send chat messages through Helix, warning once if it doesn't
+func canSendChatMessages(ctx context.Context, refresher *renewusertoken.Refresher, accessToken string) bool {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	v, err := refresher.Validate(ctx, accessToken)
+	if err != nil {
+		log.Warnf("failed to check the scopes of the token, assuming it can send chat messages: %s", err)
+		return true
+	}
+	for _, scope := range v.Scopes {
+		if scope == "user:write:chat" {
+			return true
+		}
+	}
+	log.Warn("the token lacks the user:write:chat scope, replies mention users instead of being threaded until it's authorized again with `go run . auth`")
+	return false
+}
+
+// replyTo sends message as a threaded reply to parent if replies go through Helix, and otherwise or if that fails
+// as a plain message, which mentions the author of parent if mention is set
+func (state *state) replyTo(parent twitchirc.PrivateMessage, message string, mention bool) {
+	fallback := state.mentioning(parent, message, mention)
+	broadcasterID, known := state.channelIDs[parent.Channel]
+	if state.replier == nil || !known || parent.ID == "" {
+		state.say(parent.Channel, fallback)
+		return
+	}
+	_, err := state.deliver(parent.Channel, message, true, func(message string) error {
+		return state.replier.SendChatMessage(state.ctx, twitchapi.ChatMessage{
+			BroadcasterID:        broadcasterID,
+			SenderID:             state.selfUserId,
+			Message:              message,
+			ReplyParentMessageID: parent.ID,
+		})
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"channel": parent.Channel,
+			"message": message,
+		}).Warnf("failed to send threaded reply, sending it as a message instead: %s", err)
+		state.say(parent.Channel, fallback)
+	}
+}
+
+// mentioning prefixes message with a mention of the author of m if mention is set and the chat modes allow it
+func (state *state) mentioning(m twitchirc.PrivateMessage, message string, mention bool) string {
+	if !mention || state.userStates.Get(m.Channel).Exempt(state.roomStates.Get(m.Channel)).EmoteOnly { // mentions aren't emotes
+		return message
+	}
+	return fmt.Sprintf("@%s, %s", m.User.DisplayName, message)
+}
+
+// deliver sends message to channel with send, unless the rate limit or chat modes don't allow it, and reports whether it was sent.
+// send gets the message as it has to be sent in the chat modes, e.g. made unique in unique-chat mode.
+// Messages that are echoed back over IRC, like those sent through Helix, are counted towards the rate limit by onSelfMessage.
+func (state *state) deliver(channel string, message string, echoed bool, send func(message string) error) (bool, error) {
+	if !state.hasBudget(channel) {
 		log.WithFields(log.Fields{
 			"personal-message-velocity": fmt.Sprintf("%f messages/second", state.personalMessageQueue.Velocity()),
 		}).Info("not sending message because personal rate limit was hit")
-		return // twitch global rate limit of 20 messages per 30 seconds
+		state.decide(channel, decisionlog.ActionSkip, message, "personal rate limit was hit")
+		return false, nil // twitch global rate limit of 20 messages per 30 seconds, or 100 where the bot is elevated
+	}
+	modes := state.userStates.Get(channel).Exempt(state.roomStates.Get(channel))
+	if modes.SubsOnly {
+		state.decide(channel, decisionlog.ActionSkip, message, "chat is in subs-only mode")
+		return false, nil
+	}
+	if modes.FollowersOnlyBlocks() {
+		state.decide(channel, decisionlog.ActionSkip, message, fmt.Sprintf("chat is in %d minute followers-only mode, which the bot doesn't meet", modes.FollowersOnly))
+		return false, nil
+	}
+	if modes.EmoteOnly && !state.emoteCache.MessageContainsOnlyEmotes(message, channel) {
+		state.decide(channel, decisionlog.ActionSkip, message, "chat is in emote-only mode")
+		return false, nil
 	}
-	state.client.Say(channel, message)
-	state.personalMessageQueue.Push(time.Now())
+	if wait := state.slowModeWait(channel); wait > 0 {
+		state.decide(channel, decisionlog.ActionSkip, message, fmt.Sprintf("slow mode allows talking again in %s", wait.Round(time.Second)))
+		return false, nil
+	}
+	if state.colorState != nil {
+		state.colorState.BeforeMessage(state.ctx)
+	}
+	err := send(modes.Unique(message))
+	if err != nil {
+		return false, err
+	}
+	if !echoed {
+		state.personalMessageQueue.Push(state.clock.Now())
+	}
+	state.roomStates.Sent(channel)
+	return true, nil
+}
+
+// hasBudget tells whether the rate limit allows another message in channel
+func (state *state) hasBudget(channel string) bool {
+	if state.userStates.Get(channel).Elevated() {
+		return state.personalMessageQueue.HasElevatedBudget()
+	}
+	return state.personalMessageQueue.HasBudget()
+}
+
+// slowModeWait returns how long slow mode keeps the bot from talking in channel, unless it's exempt
+func (state *state) slowModeWait(channel string) time.Duration {
+	if state.userStates.Get(channel).Elevated() {
+		return 0
+	}
+	return state.roomStates.SlowModeWait(channel)
 }
 
-func (state *state) spamBot(m twitchirc.PrivateMessage) {
-	mq := state.messageQueuesByChannel[m.Channel]
-	words := strings.SplitN(m.Message, " ", 6)
-	if len(words) == 6 {
+// waitForSlowMode waits until slow mode allows the bot to talk in channel again
+func (state *state) waitForSlowMode(channel string) error {
+	return clock.SleepContext(state.ctx, state.clock, state.slowModeWait(channel))
+}
+
+func (state *state) spamBot(m twitchirc.PrivateMessage, config echoConfig) {
+	mq, _ := state.messageQueue(m.Channel)
+	words := strings.SplitN(m.Message, " ", config.MaxWords+1)
+	if len(words) > config.MaxWords {
 		return // don't add long messages to queue for perf reasons
 	}
+	mq.Lock()
+	defer mq.Unlock()
 	mq.Push(m)
 	if mq.Velocity() < state.minimumChatVelocity {
 		return // don't try to echo spammed messages in slow chat
 	}
-	spammedMessage, err := mq.FindSpammedMessage(m.Channel, state.emoteCache)
+	if state.ctx.Err() != nil || state.isPaused(m.Channel) {
+		return // don't start echoing while shutting down or paused
+	}
+	status := state.userStates.Get(m.Channel)
+	if status.Moderator && !status.Broadcaster && !state.echoAsModerator {
+		return // moderators are supposed to moderate spam, not join in
+	}
+	if state.slowModeWait(m.Channel) > 0 {
+		return // spam is over by the time slow mode would allow echoing it
+	}
+	modes := status.Exempt(state.roomStates.Get(m.Channel))
+	spammedMessage, err := mq.FindSpammedMessage(m.Channel, state.emoteCache, func(sentence string) bool {
+		return modes.Allows(sentence, state.emoteCache.MessageContainsOnlyEmotes(sentence, m.Channel))
+	})
 	if err == nil {
 		state.say(m.Channel, spammedMessage)
 		log.WithFields(log.Fields{
 			"channel": m.Channel,
 			"message": spammedMessage,
 		}).Info("echoed spammed message")
+		state.decide(m.Channel, decisionlog.ActionEcho, spammedMessage, fmt.Sprintf("spammed at %.2f messages/second", mq.Velocity()))
 		mq.Clear()
 	}
 }
 
-func (state *state) doesNotMentionOthers(m twitchirc.PrivateMessage, usersInChannel []string) bool {
+func (state *state) doesNotMentionOthers(m twitchirc.PrivateMessage, usersInChannel []string, mentions *mention.Detector) bool {
 	if len(usersInChannel) == 0 {
 		return false
 	}
-
-	for _, userInChannel := range usersInChannel {
-		if strings.ToLower(userInChannel) == state.selfUsername {
-			continue
-		}
-		if strings.Contains(strings.ToLower(m.Message), strings.ToLower(userInChannel)) {
-			return false
-		}
-	}
-	return true
+	return !mentions.MentionsAnyOf(m.Message, usersInChannel)
 }
 
-func (state *state) autoReply(m twitchirc.PrivateMessage) {
-	cooldown := 30 * time.Second
+func (state *state) autoReply(m twitchirc.PrivateMessage, f *autoReply) {
+	config := f.config
+	if config.strategy(m.Channel).IsSilent() {
+		return
+	}
+	cooldown := time.Duration(config.CooldownSeconds) * time.Second
+	state.autoReplyTimesLock.Lock()
 	lastReplyTime, lastReplyTimeFound := state.autoReplyTimes[m.User.Name]
+	state.autoReplyTimesLock.Unlock()
 
-	containsMyName := strings.Contains(strings.ToLower(m.Message), state.selfUsername) ||
-		state.selfDisplayname != "" && strings.Contains(strings.ToLower(m.Message), state.selfDisplayname)
+	containsMyName := f.mentions.Mentions(m)
 	isFromMe := strings.ToLower(m.User.Name) == state.selfUsername
-	isNotOnCooldown := !lastReplyTimeFound || time.Since(lastReplyTime) > cooldown
+	isNotOnCooldown := !lastReplyTimeFound || state.clock.Since(lastReplyTime) > cooldown
 	isFromMod, _ := m.User.Badges["moderator"]
 	isFromStaff, _ := m.User.Badges["staff"]
 	isFromAdmin, _ := m.User.Badges["admin"]
 	isFromScaryPerson := isFromMod == 1 || isFromStaff == 1 || isFromAdmin == 1
 
-	if containsMyName && !isFromMe && isNotOnCooldown && !isFromScaryPerson {
-		go state.sendAutoReply(m)
+	if containsMyName && !isFromMe && isNotOnCooldown && !isFromScaryPerson && state.ctx.Err() == nil && !state.isPaused(m.Channel) {
+		delay := time.Duration((state.randomFloat32()*10)+2) * time.Second
+		id := state.addPendingReply(m.Channel, m.User.Name, delay)
+		due := state.clock.After(delay) // before the goroutine runs, so the delay starts with the message
+		state.outgoing.Add(1)
+		go func() {
+			defer state.outgoing.Done()
+			defer state.removePendingReply(id)
+			state.sendAutoReply(m, due, f)
+		}()
 	}
 }
 
-func (state *state) sendAutoReply(m twitchirc.PrivateMessage) {
-	time.Sleep(time.Duration((rand.Float32()*10)+2) * time.Second)
+// pendingReply is an autoreply waiting for its delay to pass
+type pendingReply struct {
+	channel string
+	user    string
+	due     time.Time
+}
+
+func (state *state) addPendingReply(channel string, user string, delay time.Duration) int {
+	state.pendingRepliesLock.Lock()
+	defer state.pendingRepliesLock.Unlock()
+	id := state.nextPendingReplyID
+	state.nextPendingReplyID++
+	state.pendingReplies[id] = pendingReply{
+		channel: channel,
+		user:    user,
+		due:     state.clock.Now().Add(delay),
+	}
+	return id
+}
+
+func (state *state) removePendingReply(id int) {
+	state.pendingRepliesLock.Lock()
+	defer state.pendingRepliesLock.Unlock()
+	delete(state.pendingReplies, id)
+}
+
+func (state *state) randomFloat32() float32 {
+	state.randomLock.Lock()
+	defer state.randomLock.Unlock()
+	return state.random.Float32()
+}
+
+func (state *state) randomIntn(n int) int {
+	state.randomLock.Lock()
+	defer state.randomLock.Unlock()
+	return state.random.Intn(n)
+}
+
+func (state *state) pendingReplyCount() int {
+	state.pendingRepliesLock.Lock()
+	defer state.pendingRepliesLock.Unlock()
+	return len(state.pendingReplies)
+}
+
+// sendAutoReply replies to m once due fires
+func (state *state) sendAutoReply(m twitchirc.PrivateMessage, due <-chan time.Time, f *autoReply) {
+	config := f.config
+	select {
+	case <-due:
+	case <-state.ctx.Done(): // the bot is shutting down, in which case the reply is flushed right away
+	}
 
 	usersInChannel, err := state.client.Userlist(m.Channel)
 	if err != nil {
 		log.Infof("failed to get userlist: %s\n", err)
 		return
 	}
-	if !state.doesNotMentionOthers(m, usersInChannel) {
+	if !state.doesNotMentionOthers(m, usersInChannel, f.mentions) {
 		log.WithFields(log.Fields{
 			"channel": m.Channel,
 			"user":    m.User.Name,
 		}).Info("not autoreplying because message mentions others")
+		state.decide(m.Channel, decisionlog.ActionSkip, m.Message, "autoreply would mention others")
 		return
 	}
 
-	maxEmotes := 24
 	emotesToReply := state.emoteCache.MessageWithOnlyEmotes(m.Message, m.Channel)
 	cappedEmotes := []string{}
-	splitEmotes := strings.SplitN(emotesToReply, " ", maxEmotes+1)
+	splitEmotes := strings.SplitN(emotesToReply, " ", config.MaxEmotes+1)
 	for i, emote := range splitEmotes {
-		if i == maxEmotes {
+		if i == config.MaxEmotes {
 			break
 		}
 		cappedEmotes = append(cappedEmotes, emote)
 	}
 	emotesToReplyCapped := strings.Join(cappedEmotes, " ")
 
-	replyMessage := fmt.Sprintf("@%s, %s", m.User.DisplayName, emotesToReplyCapped)
-	if emotesToReplyCapped != "" {
-		state.autoReplyTimes[m.User.Name] = time.Now()
-		state.say(m.Channel, replyMessage)
+	text, mentionUser := config.strategy(m.Channel).Reply(replystrategy.Context{
+		User:         m.User.DisplayName,
+		Channel:      m.Channel,
+		Time:         state.clock.Now(),
+		Emotes:       emotesToReplyCapped,
+		PopularEmote: f.popularity.Most(m.Channel),
+	}, state.randomIntn)
+	replyMessage := text
+	if !config.ThreadedReplies {
+		replyMessage = state.mentioning(m, text, mentionUser)
+	}
+	if text != "" {
+		_ = state.waitForSlowMode(m.Channel) // when shutting down, say skips the reply if slow mode doesn't allow it yet
+		state.autoReplyTimesLock.Lock()
+		state.autoReplyTimes[m.User.Name] = state.clock.Now()
+		state.autoReplyTimesLock.Unlock()
+		if config.ThreadedReplies {
+			state.replyTo(m, replyMessage, mentionUser)
+		} else {
+			state.say(m.Channel, replyMessage)
+		}
 		log.WithFields(log.Fields{
 			"channel":       m.Channel,
 			"user":          m.User.Name,
 			"reply-message": replyMessage,
 		}).Info("autoreplied")
+		state.decide(m.Channel, decisionlog.ActionAutoReply, replyMessage, fmt.Sprintf("%s mentioned the bot", m.User.Name))
 	}
 }
 
@@ -303,6 +899,9 @@ func (state *state) makePyramids(m twitchirc.PrivateMessage) {
 			return
 		}
 		for i := 0; i < size; i++ {
+			if state.isPaused(m.Channel) {
+				return
+			}
 			message := ""
 			for j := 1; j <= i+1; j++ {
 				if j != 1 {
@@ -310,10 +909,15 @@ func (state *state) makePyramids(m twitchirc.PrivateMessage) {
 				}
 				message += atomicMessage
 			}
-			time.Sleep(time.Duration(delay) * time.Millisecond)
+			if clock.SleepContext(state.ctx, state.clock, time.Duration(delay)*time.Millisecond) != nil || state.waitForSlowMode(m.Channel) != nil {
+				return
+			}
 			state.say(m.Channel, message)
 		}
 		for i := size - 2; i >= 0; i-- {
+			if state.isPaused(m.Channel) {
+				return
+			}
 			message := ""
 			for j := 1; j <= i+1; j++ {
 				if j != 1 {
@@ -321,7 +925,9 @@ func (state *state) makePyramids(m twitchirc.PrivateMessage) {
 				}
 				message += atomicMessage
 			}
-			time.Sleep(time.Duration(delay) * time.Millisecond)
+			if clock.SleepContext(state.ctx, state.clock, time.Duration(delay)*time.Millisecond) != nil || state.waitForSlowMode(m.Channel) != nil {
+				return
+			}
 			state.say(m.Channel, message)
 		}
 		log.WithFields(log.Fields{
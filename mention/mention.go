@@ -0,0 +1,146 @@
+package mention
+
+import (
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"strings"
+	"unicode"
+)
+
+// koreanSuffixes are particles and honorifics written right after a name, e.g. 하루야 or 하루님
+var koreanSuffixes = []string{"님", "씨", "쨩", "짱", "아", "야", "이", "가", "은", "는", "을", "를", "의", "도", "에게", "한테", "랑", "이랑", "하고"}
+
+// Tokenize splits message into lowercased words made of letters, digits and underscores.
+// Links are left out, so a channel URL doesn't count as mentioning its streamer.
+func Tokenize(message string) []string {
+	tokens := []string{}
+	for _, field := range strings.Fields(message) {
+		if isLink(field) {
+			continue
+		}
+		words := strings.FieldsFunc(field, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+		})
+		for _, word := range words {
+			tokens = append(tokens, strings.ToLower(word))
+		}
+	}
+	return tokens
+}
+
+func isLink(field string) bool {
+	lower := strings.ToLower(field)
+	return strings.Contains(lower, "://") || strings.HasPrefix(lower, "www.") || strings.Contains(lower, ".tv/") || strings.Contains(lower, ".com/")
+}
+
+// Detector recognizes messages addressed to the bot
+type Detector struct {
+	username string
+	names    map[string]bool // username, display name and aliases of a single token
+	phrases  [][]string      // names of several tokens, like "haru bot" or "haru-chan", tokenized
+	korean   []string        // names written in Hangul, which can have particles attached
+}
+
+func NewDetector(username string, displayName string, aliases []string) *Detector {
+	d := &Detector{
+		username: strings.ToLower(username),
+		names:    map[string]bool{},
+	}
+	phrases := map[string]bool{}
+	for _, name := range append([]string{username, displayName}, aliases...) {
+		// names are split like messages are, so they're matched token by token
+		tokens := Tokenize(name)
+		if len(tokens) > 1 {
+			if phrase := strings.Join(tokens, " "); !phrases[phrase] {
+				phrases[phrase] = true
+				d.phrases = append(d.phrases, tokens)
+			}
+			continue
+		}
+		if len(tokens) == 0 || d.names[tokens[0]] {
+			continue
+		}
+		d.names[tokens[0]] = true
+		if isHangul(tokens[0]) {
+			d.korean = append(d.korean, tokens[0])
+		}
+	}
+	return d
+}
+
+func isHangul(name string) bool {
+	for _, r := range name {
+		if unicode.Is(unicode.Hangul, r) {
+			return true
+		}
+	}
+	return false
+}
+
+// Mentions tells whether m mentions the bot by one of its names or is a reply to one of its messages
+func (d *Detector) Mentions(m twitchirc.PrivateMessage) bool {
+	if strings.ToLower(m.Tags["reply-parent-user-login"]) == d.username {
+		return true
+	}
+	tokens := Tokenize(m.Message)
+	for i, token := range tokens {
+		if d.isName(token) || d.startsPhrase(tokens[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// startsPhrase tells whether tokens start with one of the names of several tokens
+func (d *Detector) startsPhrase(tokens []string) bool {
+	for _, phrase := range d.phrases {
+		if len(tokens) < len(phrase) {
+			continue
+		}
+		matches := true
+		for i, token := range phrase {
+			if tokens[i] != token {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Detector) isName(token string) bool {
+	if d.names[token] {
+		return true
+	}
+	for _, name := range d.korean {
+		if !strings.HasPrefix(token, name) {
+			continue
+		}
+		rest := strings.TrimPrefix(token, name)
+		for _, suffix := range koreanSuffixes {
+			if rest == suffix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// MentionsAnyOf tells whether message mentions any of users as a whole word, ignoring the bot itself
+func (d *Detector) MentionsAnyOf(message string, users []string) bool {
+	others := map[string]bool{}
+	for _, user := range users {
+		user = strings.ToLower(user)
+		if user != d.username {
+			others[user] = true
+		}
+	}
+	for _, token := range Tokenize(message) {
+		if others[token] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package mention
+
+import (
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"testing"
+)
+
+func TestDetector_Mentions(t *testing.T) {
+	d := NewDetector("HaruIsWaifu", "하루", []string{"haru", "mister bot", "Robo-Chan", "@waifu"})
+	tests := []struct {
+		name    string
+		message string
+		tags    map[string]string
+		want    bool
+	}{
+		{"at mention", "@HaruIsWaifu KEKW", nil, true},
+		{"with punctuation", "hi haruiswaifu, how are you?", nil, true},
+		{"possessive", "haruiswaifu's emotes", nil, true},
+		{"longer word", "haruiswaifus KEKW", nil, false},
+		{"inside a word", "xharuiswaifux", nil, false},
+		{"url", "https://twitch.tv/haruiswaifu", nil, false},
+		{"bare url", "twitch.tv/haruiswaifu", nil, false},
+		{"alias", "Haru KEKW", nil, true},
+		{"alias inside a word", "harugoesbrr", nil, false},
+		{"alias with a space", "hi Mister Bot KEKW", nil, true},
+		{"alias with punctuation", "robo-chan!", nil, true},
+		{"alias with punctuation split by a space", "hey robo chan", nil, true},
+		{"alias with an at", "@waifu", nil, true},
+		{"part of an alias", "mister robo", nil, false},
+		{"alias in the wrong order", "bot mister", nil, false},
+		{"korean display name", "하루 안녕", nil, true},
+		{"korean with particle", "하루야 안녕", nil, true},
+		{"korean honorific", "@하루님", nil, true},
+		{"other korean word", "하루종일", nil, false},
+		{"reply to the bot", "KEKW", map[string]string{"reply-parent-user-login": "haruiswaifu"}, true},
+		{"reply to someone else", "KEKW", map[string]string{"reply-parent-user-login": "forsen"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := twitchirc.PrivateMessage{Message: tt.message, Tags: tt.tags}
+			if got := d.Mentions(m); got != tt.want {
+				t.Errorf("Mentions(%q) = %v, want %v", tt.message, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetector_MentionsAnyOf(t *testing.T) {
+	d := NewDetector("haruiswaifu", "", nil)
+	users := []string{"HaruIsWaifu", "al", "Forsen"}
+	tests := []struct {
+		message string
+		want    bool
+	}{
+		{"@haruiswaifu KEKW", false},
+		{"that's totally normal", false},
+		{"@forsen KEKW", true},
+		{"al, KEKW", true},
+		{"https://twitch.tv/forsen", false},
+	}
+	for _, tt := range tests {
+		if got := d.MentionsAnyOf(tt.message, users); got != tt.want {
+			t.Errorf("MentionsAnyOf(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+}
@@ -2,6 +2,7 @@ package messagequeue
 
 import (
 	"errors"
+	"fmt"
 	twitchirc "github.com/gempir/go-twitch-irc/v2"
 	"harubot/emotes"
 	"sort"
@@ -50,6 +51,34 @@ var thresholds = map[string][]float32{
 	"zoil":          {12, 9, 8},
 }
 var defaultThresholds = []float32{10, 8, 7}
+var thresholdsLock sync.RWMutex
+
+// Thresholds returns how many unique users have to send a sentence of 1, 2 and 3 or more words in channel before it's echoed
+func Thresholds(channel string) []float32 {
+	thresholdsLock.RLock()
+	defer thresholdsLock.RUnlock()
+	threshs, found := thresholds[channel]
+	if !found {
+		threshs = defaultThresholds
+	}
+	return append([]float32{}, threshs...)
+}
+
+// SetThreshold changes the threshold for sentences of wordCount words in channel at runtime, wordCount 3 covering longer sentences too
+func SetThreshold(channel string, wordCount int, value float32) error {
+	if wordCount < 1 || wordCount > len(defaultThresholds) {
+		return fmt.Errorf("word count has to be between 1 and %d", len(defaultThresholds))
+	}
+	if value < 1 {
+		return errors.New("threshold has to be at least 1")
+	}
+	threshs := Thresholds(channel)
+	threshs[wordCount-1] = value
+	thresholdsLock.Lock()
+	defer thresholdsLock.Unlock()
+	thresholds[channel] = threshs
+	return nil
+}
 
 // pushes new element to end of queue
 func (mq *MessageQueue) Push(m twitchirc.PrivateMessage) {
@@ -75,6 +104,11 @@ func (mq *MessageQueue) length() int {
 	return len(mq.queue)
 }
 
+// Messages returns a copy of the queued messages, oldest first
+func (mq *MessageQueue) Messages() []twitchirc.PrivateMessage {
+	return append([]twitchirc.PrivateMessage{}, mq.queue...)
+}
+
 func (mq *MessageQueue) Clear() {
 	mq.queue = []twitchirc.PrivateMessage{}
 }
@@ -92,9 +126,90 @@ func (mq *MessageQueue) Velocity() float64 {
 	return float64(amountOfMessages) / timeSpanSeconds
 }
 
-// FindSpammedMessage finds the most spammed sentence (any in order combination of words) based on messages by unique users
-func (mq *MessageQueue) FindSpammedMessage(channel string, emoteCache *emotes.Cache) (string, error) {
+// FindSpammedMessage finds the most spammed sentence (any in order combination of words) based on messages by unique users.
+// Sentences allowed rejects, e.g. because of the chat modes of the channel, are skipped.
+func (mq *MessageQueue) FindSpammedMessage(channel string, emoteCache *emotes.Cache, allowed func(sentence string) bool) (string, error) {
 	initialLength := len(mq.queue)
+	sbc := mq.countSentences()
+
+	threshs := Thresholds(channel)
+	for i, sentence := range sbc {
+		similarSentences := sbc.FindSimilarSentences(i)
+		countOfSimilarSentences := similarSentences.TotalCount()
+		totalCount := countOfSimilarSentences + sentence.Count
+		similarSentencesWithSelf := append(similarSentences, sentence)
+		var sentenceVariantWithMaxOccurrences *Sentence
+		for _, similarSentence := range similarSentencesWithSelf {
+			if sentenceVariantWithMaxOccurrences == nil || similarSentence.Count > sentenceVariantWithMaxOccurrences.Count {
+				sentenceVariantWithMaxOccurrences = &similarSentence
+			}
+		}
+
+		if float32(totalCount) >= thresholdFor(threshs, sentence.WordCount) {
+			s := sentenceVariantWithMaxOccurrences.Text
+			if !strings.HasPrefix(strings.ToLower(s), "!bet") && !strings.Contains(strings.ToLower(s), "residentsleeper") && !strings.Contains(strings.ToLower(s), "nigger") && s != "\U000e0000" {
+				if len(mq.queue) >= initialLength { // check to ensure queue hasn't cleared since starting to find message
+					if emoteCache.SentenceContainsEmotes(s, channel) && allowed(s) {
+						if mq.lastMessage == s {
+							mq.lastMessage = s + " \U000e0000"
+							return mq.lastMessage, nil
+						}
+						mq.lastMessage = s
+						return s, nil
+					}
+				}
+			}
+		}
+	}
+
+	return "", errors.New("unable to find spammed message that meets requirements")
+}
+
+// thresholdFor picks the threshold for sentences of wordCount words, the last one covering all longer sentences
+func thresholdFor(threshs []float32, wordCount int) float32 {
+	thresholdsIndex := wordCount - 1
+	if thresholdsIndex > len(threshs)-1 {
+		thresholdsIndex = len(threshs) - 1
+	}
+	return threshs[thresholdsIndex]
+}
+
+// Candidate is a sentence that could be echoed once enough users spam it
+type Candidate struct {
+	Text      string  `json:"text"`
+	Count     int     `json:"count"` // unique users that sent it, in any capitalization
+	Threshold float32 `json:"threshold"`
+}
+
+// Candidates returns up to limit of the sentences in the queue that are closest to their threshold
+func (mq *MessageQueue) Candidates(channel string, limit int) []Candidate {
+	sbc := mq.countSentences()
+	threshs := Thresholds(channel)
+	seen := map[string]bool{}
+	candidates := []Candidate{}
+	for i, sentence := range sbc {
+		lower := strings.ToLower(sentence.Text)
+		if seen[lower] {
+			continue
+		}
+		seen[lower] = true
+		candidates = append(candidates, Candidate{
+			Text:      sentence.Text,
+			Count:     sbc.FindSimilarSentences(i).TotalCount() + sentence.Count,
+			Threshold: thresholdFor(threshs, sentence.WordCount),
+		})
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return float32(candidates[i].Count)/candidates[i].Threshold > float32(candidates[j].Count)/candidates[j].Threshold
+	})
+	if len(candidates) > limit {
+		candidates = candidates[:limit]
+	}
+	return candidates
+}
+
+// countSentences counts every in order combination of words in the queued messages by unique users
+func (mq *MessageQueue) countSentences() SortedSentences {
 	sentenceCountsByWordCount := map[int]map[string]int{}
 	messageAuthors := map[string]map[string]bool{}
 	sentenceTimes := map[string][]time.Time{}
@@ -173,44 +288,5 @@ func (mq *MessageQueue) FindSpammedMessage(channel string, emoteCache *emotes.Ca
 		}
 	}
 	sort.Sort(sbc)
-
-	for i, sentence := range sbc {
-		wordCount := sentence.WordCount
-		thresholdsIndex := wordCount - 1
-		if thresholdsIndex > len(defaultThresholds)-1 {
-			thresholdsIndex = len(defaultThresholds) - 1
-		}
-		similarSentences := sbc.FindSimilarSentences(i)
-		countOfSimilarSentences := similarSentences.TotalCount()
-		totalCount := countOfSimilarSentences + sentence.Count
-		similarSentencesWithSelf := append(similarSentences, sentence)
-		var sentenceVariantWithMaxOccurrences *Sentence
-		for _, similarSentence := range similarSentencesWithSelf {
-			if sentenceVariantWithMaxOccurrences == nil || similarSentence.Count > sentenceVariantWithMaxOccurrences.Count {
-				sentenceVariantWithMaxOccurrences = &similarSentence
-			}
-		}
-
-		threshs, found := thresholds[channel]
-		if !found {
-			threshs = defaultThresholds
-		}
-		if float32(totalCount) >= threshs[thresholdsIndex] {
-			s := sentenceVariantWithMaxOccurrences.Text
-			if !strings.HasPrefix(strings.ToLower(s), "!bet") && !strings.Contains(strings.ToLower(s), "residentsleeper") && !strings.Contains(strings.ToLower(s), "nigger") && s != "\U000e0000" {
-				if len(mq.queue) >= initialLength { // check to ensure queue hasn't cleared since starting to find message
-					if emoteCache.SentenceContainsEmotes(s, channel) {
-						if mq.lastMessage == s {
-							mq.lastMessage = s + " \U000e0000"
-							return mq.lastMessage, nil
-						}
-						mq.lastMessage = s
-						return s, nil
-					}
-				}
-			}
-		}
-	}
-
-	return "", errors.New("unable to find spammed message that meets requirements")
+	return sbc
 }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	log "github.com/sirupsen/logrus"
+	decisionlog "harubot/decision-log"
+	"harubot/moderation"
+	roomstate "harubot/room-state"
+)
+
+// onClearChat handles a CLEARCHAT, received live or replayed from a chat log
+func (state *state) onClearChat(m twitchirc.ClearChatMessage) {
+	state.onIncident(state.moderation.OnClearChat(m))
+	state.features.ClearChat(m)
+}
+
+// onClearMessage handles a CLEARMSG, received live or replayed from a chat log
+func (state *state) onClearMessage(m twitchirc.ClearMessage) {
+	state.onIncident(state.moderation.OnClearMessage(m))
+}
+
+// onNotice handles a NOTICE, received live or replayed from a chat log
+func (state *state) onNotice(m twitchirc.NoticeMessage) {
+	if roomstate.FollowersOnlyNotices[m.MsgID] {
+		log.WithFields(log.Fields{
+			"channel": m.Channel,
+		}).Warn("staying quiet in followers-only mode, the bot doesn't follow long enough")
+		state.roomStates.NotFollowing(m.Channel)
+	}
+	state.onIncident(state.moderation.OnNotice(m))
+}
+
+// onIncident backs off from a channel moderators acted in and forgets what was queued there, so nothing from before is echoed afterwards
+func (state *state) onIncident(incident *moderation.Incident) {
+	if incident == nil {
+		return
+	}
+	log.WithFields(log.Fields{
+		"channel": incident.Channel,
+		"kind":    incident.Kind,
+		"detail":  incident.Detail,
+		"backoff": incident.Backoff,
+	}).Warn("moderation incident")
+	state.decide(incident.Channel, decisionlog.ActionIncident, incident.Detail, incident.Kind)
+
+	if mq, joined := state.messageQueue(incident.Channel); joined {
+		mq.Lock()
+		mq.Clear()
+		mq.Unlock()
+	}
+	reason := fmt.Sprintf("%s: %s", incident.Kind, incident.Detail)
+	switch {
+	case incident.Indefinite:
+		state.pause(incident.Channel, 0, reason)
+	case incident.Backoff > 0:
+		state.pause(incident.Channel, incident.Backoff, reason)
+	}
+}
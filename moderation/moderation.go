@@ -0,0 +1,169 @@
+package moderation
+
+import (
+	"fmt"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"harubot/clock"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	KindTimeout        = "timeout"
+	KindBan            = "ban"
+	KindDeletedMessage = "deleted-message"
+	KindClear          = "clear"
+)
+
+const (
+	clearBackoff          = 2 * time.Minute
+	deletionBackoff       = 5 * time.Minute
+	maxDeletionBackoff    = 6 * time.Hour
+	deletionMemory        = 24 * time.Hour
+	maxRecordedIncidents  = 100
+	defaultTimeoutBackoff = 10 * time.Minute // for timeouts the bot only learns about from failing to send
+)
+
+var timedOutPattern = regexp.MustCompile(`(\d+) more seconds?`)
+
+// Incident is something moderators did that the bot should back off from
+type Incident struct {
+	Time       time.Time     `json:"time"`
+	Channel    string        `json:"channel"`
+	Kind       string        `json:"kind"`
+	Detail     string        `json:"detail"`
+	Backoff    time.Duration `json:"backoff"`
+	Indefinite bool          `json:"indefinite"` // back off until resumed by hand, e.g. after a ban
+}
+
+// Monitor recognizes moderation aimed at the bot or its channels in chat events and decides how long to back off
+type Monitor struct {
+	selfUsername string
+	selfUserID   string
+	clock        clock.Clock
+	incidents    []Incident
+	deletions    map[string][]time.Time // recent deletions of the bot's messages per channel
+	lock         sync.Mutex
+}
+
+func NewMonitor(selfUsername string, selfUserID string, clk clock.Clock) *Monitor {
+	return &Monitor{
+		selfUsername: strings.ToLower(selfUsername),
+		selfUserID:   selfUserID,
+		clock:        clk,
+		incidents:    []Incident{},
+		deletions:    map[string][]time.Time{},
+	}
+}
+
+// OnClearChat recognizes timeouts and bans of the bot and clears of the whole chat
+func (mon *Monitor) OnClearChat(m twitchirc.ClearChatMessage) *Incident {
+	if m.TargetUserID == "" && m.TargetUsername == "" {
+		return mon.record(Incident{
+			Channel: m.Channel,
+			Kind:    KindClear,
+			Detail:  "chat was cleared",
+			Backoff: clearBackoff,
+		})
+	}
+	isMe := m.TargetUserID != "" && m.TargetUserID == mon.selfUserID ||
+		strings.ToLower(m.TargetUsername) == mon.selfUsername
+	if !isMe {
+		return nil
+	}
+	if m.BanDuration > 0 {
+		return mon.record(Incident{
+			Channel: m.Channel,
+			Kind:    KindTimeout,
+			Detail:  fmt.Sprintf("timed out for %ds", m.BanDuration),
+			Backoff: time.Duration(m.BanDuration) * time.Second,
+		})
+	}
+	return mon.record(Incident{
+		Channel:    m.Channel,
+		Kind:       KindBan,
+		Detail:     "banned",
+		Indefinite: true,
+	})
+}
+
+// OnClearMessage recognizes deleted messages of the bot, backing off longer the more of them were deleted lately
+func (mon *Monitor) OnClearMessage(m twitchirc.ClearMessage) *Incident {
+	if strings.ToLower(m.Login) != mon.selfUsername {
+		return nil
+	}
+	mon.lock.Lock()
+	now := mon.clock.Now()
+	recent := []time.Time{}
+	for _, t := range mon.deletions[m.Channel] {
+		if now.Sub(t) < deletionMemory {
+			recent = append(recent, t)
+		}
+	}
+	backoff := deletionBackoff
+	for range recent {
+		backoff *= 2
+		if backoff >= maxDeletionBackoff {
+			backoff = maxDeletionBackoff
+			break
+		}
+	}
+	mon.deletions[m.Channel] = append(recent, now)
+	mon.lock.Unlock()
+
+	return mon.record(Incident{
+		Channel: m.Channel,
+		Kind:    KindDeletedMessage,
+		Detail:  fmt.Sprintf("deleted %q, %d in the last day", m.Message, len(recent)+1),
+		Backoff: backoff,
+	})
+}
+
+// OnNotice recognizes messages of the bot that were refused because it's timed out or banned
+func (mon *Monitor) OnNotice(m twitchirc.NoticeMessage) *Incident {
+	switch m.MsgID {
+	case "msg_banned":
+		return mon.record(Incident{
+			Channel:    m.Channel,
+			Kind:       KindBan,
+			Detail:     m.Message,
+			Indefinite: true,
+		})
+	case "msg_timedout":
+		backoff := defaultTimeoutBackoff
+		if match := timedOutPattern.FindStringSubmatch(m.Message); match != nil {
+			seconds, err := strconv.Atoi(match[1])
+			if err == nil {
+				backoff = time.Duration(seconds) * time.Second
+			}
+		}
+		return mon.record(Incident{
+			Channel: m.Channel,
+			Kind:    KindTimeout,
+			Detail:  m.Message,
+			Backoff: backoff,
+		})
+	}
+	return nil
+}
+
+func (mon *Monitor) record(incident Incident) *Incident {
+	mon.lock.Lock()
+	defer mon.lock.Unlock()
+	incident.Time = mon.clock.Now()
+	mon.incidents = append(mon.incidents, incident)
+	if len(mon.incidents) > maxRecordedIncidents {
+		mon.incidents = mon.incidents[len(mon.incidents)-maxRecordedIncidents:]
+	}
+	return &incident
+}
+
+// Incidents returns the latest incidents, oldest first
+func (mon *Monitor) Incidents() []Incident {
+	mon.lock.Lock()
+	defer mon.lock.Unlock()
+	return append([]Incident{}, mon.incidents...)
+}
@@ -0,0 +1,77 @@
+package moderation
+
+import (
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"harubot/clock"
+	"testing"
+	"time"
+)
+
+func TestMonitor(t *testing.T) {
+	tests := []struct {
+		name           string
+		events         []twitchirc.Message
+		wantKind       string
+		wantBackoff    time.Duration
+		wantIndefinite bool
+	}{
+		{"timeout of someone else", []twitchirc.Message{
+			&twitchirc.ClearChatMessage{Channel: "xqc", TargetUserID: "1", TargetUsername: "someone", BanDuration: 600},
+		}, "", 0, false},
+		{"timeout of the bot", []twitchirc.Message{
+			&twitchirc.ClearChatMessage{Channel: "xqc", TargetUserID: "488987844", BanDuration: 600},
+		}, KindTimeout, 10 * time.Minute, false},
+		{"ban of the bot", []twitchirc.Message{
+			&twitchirc.ClearChatMessage{Channel: "xqc", TargetUsername: "HaruIsWaifu"},
+		}, KindBan, 0, true},
+		{"clear", []twitchirc.Message{
+			&twitchirc.ClearChatMessage{Channel: "xqc"},
+		}, KindClear, clearBackoff, false},
+		{"deleted message of someone else", []twitchirc.Message{
+			&twitchirc.ClearMessage{Channel: "xqc", Login: "someone"},
+		}, "", 0, false},
+		{"deleted message", []twitchirc.Message{
+			&twitchirc.ClearMessage{Channel: "xqc", Login: "haruiswaifu"},
+		}, KindDeletedMessage, deletionBackoff, false},
+		{"repeatedly deleted messages", []twitchirc.Message{
+			&twitchirc.ClearMessage{Channel: "xqc", Login: "haruiswaifu"},
+			&twitchirc.ClearMessage{Channel: "forsen", Login: "haruiswaifu"},
+			&twitchirc.ClearMessage{Channel: "xqc", Login: "haruiswaifu"},
+			&twitchirc.ClearMessage{Channel: "xqc", Login: "haruiswaifu"},
+		}, KindDeletedMessage, 4 * deletionBackoff, false},
+		{"refused while timed out", []twitchirc.Message{
+			&twitchirc.NoticeMessage{Channel: "xqc", MsgID: "msg_timedout", Message: "You are timed out for 593 more seconds."},
+		}, KindTimeout, 593 * time.Second, false},
+		{"refused while banned", []twitchirc.Message{
+			&twitchirc.NoticeMessage{Channel: "xqc", MsgID: "msg_banned", Message: "You are permanently banned from talking in xqc."},
+		}, KindBan, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mon := NewMonitor("haruiswaifu", "488987844", clock.NewVirtual(time.Now()))
+			var incident *Incident
+			for _, event := range tt.events {
+				switch m := event.(type) {
+				case *twitchirc.ClearChatMessage:
+					incident = mon.OnClearChat(*m)
+				case *twitchirc.ClearMessage:
+					incident = mon.OnClearMessage(*m)
+				case *twitchirc.NoticeMessage:
+					incident = mon.OnNotice(*m)
+				}
+			}
+			if tt.wantKind == "" {
+				if incident != nil {
+					t.Errorf("got incident %+v, want none", incident)
+				}
+				return
+			}
+			if incident == nil {
+				t.Fatalf("got no incident, want %s", tt.wantKind)
+			}
+			if incident.Kind != tt.wantKind || incident.Backoff != tt.wantBackoff || incident.Indefinite != tt.wantIndefinite {
+				t.Errorf("got %+v, want %s for %s (indefinite %v)", incident, tt.wantKind, tt.wantBackoff, tt.wantIndefinite)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	decisionlog "harubot/decision-log"
+	"harubot/pause"
+	"time"
+)
+
+// pause keeps the bot quiet in channel, or all channels if it's pause.Global, for d or until resumed if d is 0.
+// It returns the pause in effect, which is a longer one that was already active if there was one.
+func (state *state) pause(channel string, d time.Duration, reason string) pause.Pause {
+	effective := state.pauses.Pause(channel, d, reason)
+	log.WithFields(log.Fields{
+		"channel":  channel,
+		"duration": d,
+		"until":    effective.Until,
+		"reason":   reason,
+	}).Info("paused")
+	state.decide(channel, decisionlog.ActionPause, "", reason)
+	state.persistPauses()
+	return effective
+}
+
+func (state *state) resume(channel string, reason string) {
+	state.pauses.Resume(channel)
+	log.WithFields(log.Fields{
+		"channel": channel,
+		"reason":  reason,
+	}).Info("resumed")
+	state.decide(channel, decisionlog.ActionResume, "", reason)
+	state.persistPauses()
+}
+
+// persistPauses saves state right away, so pauses survive a crash and not only a shutdown
+func (state *state) persistPauses() {
+	if state.statePath == "" {
+		return
+	}
+	err := state.persist(state.statePath)
+	if err != nil {
+		log.Errorf("failed to persist pauses: %s", err)
+	}
+}
+
+// isPaused tells whether the bot should stay quiet in channel
+func (state *state) isPaused(channel string) bool {
+	return state.pauses.IsPaused(channel)
+}
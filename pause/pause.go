@@ -0,0 +1,115 @@
+package pause
+
+import (
+	"harubot/clock"
+	"sync"
+	"time"
+)
+
+// Global is the channel to pause every channel at once
+const Global = ""
+
+// Pause keeps the bot quiet until Until, or until it's resumed if Until is zero
+type Pause struct {
+	Until  time.Time `json:"until"`
+	Reason string    `json:"reason"`
+}
+
+// Pauses tracks which channels the bot should stay quiet in
+type Pauses struct {
+	byChannel map[string]Pause
+	clock     clock.Clock
+	lock      sync.Mutex
+}
+
+func NewPauses(clk clock.Clock) *Pauses {
+	return &Pauses{
+		byChannel: map[string]Pause{},
+		clock:     clk,
+	}
+}
+
+// Pause pauses channel, or all channels if it's Global, for d or indefinitely if d is 0, and returns the pause in effect.
+// A longer pause that is already active isn't shortened, in which case it's returned instead.
+func (p *Pauses) Pause(channel string, d time.Duration, reason string) Pause {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	pause := Pause{Reason: reason}
+	if d > 0 {
+		pause.Until = p.clock.Now().Add(d)
+	}
+	if current, ok := p.active(channel); ok && outlasts(current, pause) {
+		return current
+	}
+	p.byChannel[channel] = pause
+	return pause
+}
+
+// outlasts tells whether a ends after b
+func outlasts(a Pause, b Pause) bool {
+	if a.Until.IsZero() {
+		return !b.Until.IsZero()
+	}
+	return !b.Until.IsZero() && a.Until.After(b.Until)
+}
+
+// Resume lifts the pause of channel, or the global pause if it's Global, and reports whether there was one
+func (p *Pauses) Resume(channel string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, ok := p.active(channel)
+	delete(p.byChannel, channel)
+	return ok
+}
+
+// IsPaused tells whether the bot should stay quiet in channel, because of its own pause or the global one
+func (p *Pauses) IsPaused(channel string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	_, global := p.active(Global)
+	_, paused := p.active(channel)
+	return global || paused
+}
+
+// Get returns the pause of channel itself, not considering the global one
+func (p *Pauses) Get(channel string) (Pause, bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.active(channel)
+}
+
+// All returns every pause that hasn't expired yet, keyed by channel
+func (p *Pauses) All() map[string]Pause {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	all := map[string]Pause{}
+	for channel := range p.byChannel {
+		if pause, ok := p.active(channel); ok {
+			all[channel] = pause
+		}
+	}
+	return all
+}
+
+// Restore adds pauses saved from All, e.g. before a restart, dropping those that expired in the meantime
+func (p *Pauses) Restore(pauses map[string]Pause) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	for channel, pause := range pauses {
+		p.byChannel[channel] = pause
+		p.active(channel)
+	}
+}
+
+// active returns the pause of channel if it hasn't expired, forgetting it otherwise
+func (p *Pauses) active(channel string) (Pause, bool) {
+	pause, ok := p.byChannel[channel]
+	if !ok {
+		return Pause{}, false
+	}
+	if !pause.Until.IsZero() && !p.clock.Now().Before(pause.Until) {
+		delete(p.byChannel, channel)
+		return Pause{}, false
+	}
+	return pause, true
+}
@@ -0,0 +1,68 @@
+package pause
+
+import (
+	"harubot/clock"
+	"testing"
+	"time"
+)
+
+func TestPauses(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		setup   func(p *Pauses)
+		elapsed time.Duration
+		channel string
+		want    bool
+	}{
+		{"not paused", func(p *Pauses) {}, 0, "xqc", false},
+		{"paused indefinitely", func(p *Pauses) { p.Pause("xqc", 0, "") }, 24 * time.Hour, "xqc", true},
+		{"other channel", func(p *Pauses) { p.Pause("xqc", 0, "") }, 0, "forsen", false},
+		{"global", func(p *Pauses) { p.Pause(Global, 0, "") }, 0, "forsen", true},
+		{"before expiry", func(p *Pauses) { p.Pause("xqc", time.Minute, "") }, 59 * time.Second, "xqc", true},
+		{"expired", func(p *Pauses) { p.Pause("xqc", time.Minute, "") }, time.Minute, "xqc", false},
+		{"resumed", func(p *Pauses) { p.Pause("xqc", 0, ""); p.Resume("xqc") }, 0, "xqc", false},
+		{"not shortened", func(p *Pauses) { p.Pause("xqc", 0, ""); p.Pause("xqc", time.Minute, "") }, time.Hour, "xqc", true},
+		{"lengthened", func(p *Pauses) { p.Pause("xqc", time.Minute, ""); p.Pause("xqc", time.Hour, "") }, 30 * time.Minute, "xqc", true},
+		{"restored", func(p *Pauses) {
+			p.Restore(map[string]Pause{"xqc": {Until: start.Add(time.Hour)}, "forsen": {Until: start.Add(-time.Hour)}})
+		}, 0, "xqc", true},
+		{"restored expired", func(p *Pauses) {
+			p.Restore(map[string]Pause{"forsen": {Until: start.Add(-time.Hour)}})
+		}, 0, "forsen", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewVirtual(start)
+			p := NewPauses(clk)
+			tt.setup(p)
+			clk.Advance(tt.elapsed)
+			if got := p.IsPaused(tt.channel); got != tt.want {
+				t.Errorf("IsPaused(%s) = %v, want %v", tt.channel, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPauses_PauseReturnsEffectivePause(t *testing.T) {
+	start := time.Date(2022, 6, 1, 12, 0, 0, 0, time.UTC)
+	p := NewPauses(clock.NewVirtual(start))
+	tests := []struct {
+		d         time.Duration
+		reason    string
+		wantUntil time.Time
+		want      string
+	}{
+		{time.Hour, "first", start.Add(time.Hour), "first"},
+		{time.Minute, "shorter", start.Add(time.Hour), "first"},
+		{2 * time.Hour, "longer", start.Add(2 * time.Hour), "longer"},
+		{0, "indefinite", time.Time{}, "indefinite"},
+		{time.Minute, "shorter again", time.Time{}, "indefinite"},
+	}
+	for _, tt := range tests {
+		got := p.Pause("xqc", tt.d, tt.reason)
+		if !got.Until.Equal(tt.wantUntil) || got.Reason != tt.want {
+			t.Errorf("Pause(%s, %q) = %+v, want the pause %q until %s", tt.d, tt.reason, got, tt.want, tt.wantUntil)
+		}
+	}
+}
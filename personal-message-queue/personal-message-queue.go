@@ -1,54 +1,84 @@
 package personalmessagequeue
 
 import (
+	"context"
 	log "github.com/sirupsen/logrus"
+	"harubot/clock"
+	"sync"
 	"time"
 )
 
+// MaxVelocity keeps the bot below Twitch's global rate limit of 20 messages per 30 seconds
+const MaxVelocity = 0.66
+
+// MaxElevatedVelocity keeps the bot below the rate limit of 100 messages per 30 seconds in channels it's a moderator, VIP or broadcaster in
+const MaxElevatedVelocity = 3.33
+
 type PersonalMessageQueue struct {
 	timeQueue []time.Time
 	capacity  int
+	clock     clock.Clock
+	lock      sync.RWMutex // guards timeQueue, which sending and the velocity checks touch from different goroutines
 }
 
-func NewPersonalMessageQueue(capacity int) *PersonalMessageQueue {
+func NewPersonalMessageQueue(capacity int, clk clock.Clock) *PersonalMessageQueue {
 	pmq := &PersonalMessageQueue{
-		[]time.Time{},
-		capacity,
+		timeQueue: []time.Time{},
+		capacity:  capacity,
+		clock:     clk,
 	}
-	go pmq.routinelyLogVelocity()
 	return pmq
 }
 
-func (pmq *PersonalMessageQueue) routinelyLogVelocity() {
+func (pmq *PersonalMessageQueue) RoutinelyLogVelocity(ctx context.Context) {
 	for {
-		time.Sleep(10 * time.Second)
+		if clock.SleepContext(ctx, pmq.clock, 10*time.Second) != nil {
+			return
+		}
 		log.Infof("current personal velocity: %f messages/second", pmq.Velocity())
 	}
 }
 
 func (pmq *PersonalMessageQueue) Push(t time.Time) {
+	pmq.lock.Lock()
+	defer pmq.lock.Unlock()
 	if len(pmq.timeQueue) == pmq.capacity {
 		pmq.pop()
 	}
 	pmq.timeQueue = append(pmq.timeQueue, t)
 }
 
+// pop drops the oldest time, the caller holds the write lock
 func (pmq *PersonalMessageQueue) pop() {
 	pmq.timeQueue = pmq.timeQueue[1:]
 }
 
 func (pmq *PersonalMessageQueue) length() int {
+	pmq.lock.RLock()
+	defer pmq.lock.RUnlock()
 	return len(pmq.timeQueue)
 }
 
+// HasBudget reports whether another message can be sent without hitting the rate limit
+func (pmq *PersonalMessageQueue) HasBudget() bool {
+	return pmq.Velocity() <= MaxVelocity
+}
+
+// HasElevatedBudget is HasBudget for channels the bot is a moderator, VIP or broadcaster in
+func (pmq *PersonalMessageQueue) HasElevatedBudget() bool {
+	return pmq.Velocity() <= MaxElevatedVelocity
+}
+
 func (pmq *PersonalMessageQueue) Velocity() float64 {
+	pmq.lock.RLock()
+	defer pmq.lock.RUnlock()
 	mqSlice := pmq.timeQueue
 	if len(mqSlice) == 0 {
 		return 0
 	}
 	count := 0
-	start := time.Now().Add(-30 * time.Second)
-	for _, t := range pmq.timeQueue {
+	start := pmq.clock.Now().Add(-30 * time.Second)
+	for _, t := range mqSlice {
 		if t.After(start) {
 			count++
 		}
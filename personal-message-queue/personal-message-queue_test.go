@@ -0,0 +1,51 @@
+package personalmessagequeue
+
+import (
+	"harubot/clock"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPersonalMessageQueue_Velocity(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(1000, 0))
+	pmq := NewPersonalMessageQueue(5, clk)
+	for i := 0; i < 6; i++ {
+		pmq.Push(clk.Now())
+		clk.Advance(5 * time.Second)
+	}
+	// capacity of 5 drops the oldest message, all remaining ones are within the last 30 seconds
+	if got, want := pmq.Velocity(), 5.0/30.0; got != want {
+		t.Errorf("Velocity() = %f, want %f", got, want)
+	}
+
+	clk.Advance(20 * time.Second)
+	if got, want := pmq.Velocity(), 1.0/30.0; got != want {
+		t.Errorf("Velocity() after 20s = %f, want %f", got, want)
+	}
+}
+
+func TestPersonalMessageQueue_Concurrent(t *testing.T) {
+	clk := clock.NewVirtual(time.Unix(1000, 0))
+	pmq := NewPersonalMessageQueue(5, clk)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				pmq.Push(clk.Now())
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				pmq.HasBudget()
+			}
+		}()
+	}
+	wg.Wait()
+	if got := pmq.length(); got != 5 {
+		t.Errorf("length() = %d, want 5", got)
+	}
+}
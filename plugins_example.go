@@ -0,0 +1,6 @@
+//go:build example
+
+package main
+
+// features outside package main are compiled in by importing them, see the feature package
+import _ "harubot/feature/example"
@@ -1,86 +1,258 @@
 package renewusertoken
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	log "github.com/sirupsen/logrus"
+	"harubot/clock"
+	"harubot/credentials"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 )
 
-type secrets struct {
-	Username           string `json:"username"`
-	OauthKey           string `json:"oauth-key"`
-	TwitchAccessToken  string `json:"twitch-access-token"`
-	TwitchClientID     string `json:"twitch-client-id"`
-	TwitchClientSecret string `json:"twitch-client-secret"`
-	TwitchRefreshToken string `json:"twitch-refresh-token"`
-}
+const (
+	DefaultTokenURL    = "https://id.twitch.tv/oauth2/token"
+	DefaultValidateURL = "https://id.twitch.tv/oauth2/validate"
+)
 
 type tokenRefreshResponse struct {
-	AccessToken string `json:"access_token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+type ValidateResponse struct {
+	ClientID  string   `json:"client_id"`
+	Login     string   `json:"login"`
+	UserID    string   `json:"user_id"`
+	Scopes    []string `json:"scopes"`
+	ExpiresIn int      `json:"expires_in"`
+}
+
+type Config struct {
+	TokenURL       string        // defaults to DefaultTokenURL
+	ValidateURL    string        // defaults to DefaultValidateURL
+	MaxInterval    time.Duration // refresh at least this often, even if the token would stay valid longer
+	MaxAttempts    int           // attempts per refresh before waiting for the next scheduled refresh
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+}
+
+// Refresher keeps the user access token fresh, refreshing shortly before it expires and retrying failed refreshes
+type Refresher struct {
+	config      Config
+	credentials *credentials.Provider
+	httpClient  *http.Client
+	clock       clock.Clock
 }
 
-func RoutinelyRefreshToken(interval time.Duration) {
+const (
+	refreshMargin      = 10 * time.Minute // how long before expiry a token is refreshed
+	minRefreshInterval = time.Minute      // even if a new token expires sooner, so such tokens don't cause a refresh loop
+)
+
+// errPermanent marks failures that retrying won't fix, e.g. a revoked refresh token
+var errPermanent = errors.New("permanent failure")
+
+func NewRefresher(config Config, provider *credentials.Provider, clk clock.Clock) *Refresher {
+	if config.TokenURL == "" {
+		config.TokenURL = DefaultTokenURL
+	}
+	if config.ValidateURL == "" {
+		config.ValidateURL = DefaultValidateURL
+	}
+	if config.MaxInterval <= 0 {
+		config.MaxInterval = 3 * time.Hour
+	}
+	if config.MaxAttempts <= 0 {
+		config.MaxAttempts = 6
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = 2 * time.Second
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = 2 * time.Minute
+	}
+	return &Refresher{
+		config:      config,
+		credentials: provider,
+		httpClient:  &http.Client{Timeout: 30 * time.Second},
+		clock:       clk,
+	}
+}
+
+// RoutinelyRefreshToken validates the current token on start and then refreshes it whenever it's about to expire
+func (r *Refresher) RoutinelyRefreshToken(ctx context.Context) {
+	next := r.initialDelay(ctx)
 	for {
-		refreshToken()
-		time.Sleep(interval)
+		if clock.SleepContext(ctx, r.clock, next) != nil {
+			return
+		}
+		expiresIn, err := r.refreshWithRetries(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			next = r.config.MaxBackoff
+			if errors.Is(err, errPermanent) {
+				next = r.config.MaxInterval
+			}
+			log.Errorf("failed to refresh token, trying again in %s: %s", next, err)
+			continue
+		}
+		next = r.nextRefresh(expiresIn)
+		log.WithFields(log.Fields{
+			"expires-in":   expiresIn,
+			"next-refresh": next,
+		}).Info("refreshed token")
 	}
 }
 
-func refreshToken() {
-	secretsBytes, err := ioutil.ReadFile("./secrets.json")
+// initialDelay returns how long the current token can be used before it needs to be refreshed
+func (r *Refresher) initialDelay(ctx context.Context) time.Duration {
+	v, err := r.Validate(ctx, r.credentials.Get().TwitchAccessToken)
 	if err != nil {
-		log.Fatalln("failed to read secrets")
+		log.Infof("refreshing token right away because it couldn't be validated: %s", err)
+		return 0
 	}
-	s := &secrets{}
-	err = json.Unmarshal(secretsBytes, s)
-	if err != nil {
-		log.Fatalln("failed to unmarshal secrets")
+	return r.nextRefresh(time.Duration(v.ExpiresIn) * time.Second)
+}
+
+// nextRefresh returns when a token expiring in expiresIn is refreshed, Twitch reports 0 for tokens that don't expire
+func (r *Refresher) nextRefresh(expiresIn time.Duration) time.Duration {
+	if expiresIn <= 0 {
+		return r.config.MaxInterval
+	}
+	next := expiresIn - refreshMargin
+	if next < minRefreshInterval {
+		next = minRefreshInterval
 	}
+	if next > r.config.MaxInterval {
+		next = r.config.MaxInterval
+	}
+	return next
+}
+
+func (r *Refresher) refreshWithRetries(ctx context.Context) (time.Duration, error) {
+	var err error
+	for attempt := 0; attempt < r.config.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			backoff := r.backoff(attempt)
+			log.WithFields(log.Fields{
+				"attempt": attempt + 1,
+				"backoff": backoff,
+			}).Warnf("retrying token refresh: %s", err)
+			if clock.SleepContext(ctx, r.clock, backoff) != nil {
+				return 0, ctx.Err()
+			}
+		}
+		var expiresIn time.Duration
+		expiresIn, err = r.Refresh(ctx)
+		if err == nil {
+			return expiresIn, nil
+		}
+		if errors.Is(err, errPermanent) {
+			return 0, err
+		}
+	}
+	return 0, err
+}
+
+// backoff grows exponentially with the attempt and is jittered between half and all of that
+func (r *Refresher) backoff(attempt int) time.Duration {
+	backoff := r.config.InitialBackoff << (attempt - 1)
+	if backoff > r.config.MaxBackoff || backoff <= 0 {
+		backoff = r.config.MaxBackoff
+	}
+	return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+}
+
+// Refresh exchanges the refresh token for a new access token once and returns how long the new token is valid for
+func (r *Refresher) Refresh(ctx context.Context) (time.Duration, error) {
+	s := r.credentials.Get()
 
-	tokenURL := "https://id.twitch.tv/oauth2/token"
 	data := url.Values{}
 	data.Set("grant_type", "refresh_token")
 	data.Set("refresh_token", s.TwitchRefreshToken)
 	data.Set("client_id", s.TwitchClientID)
 	data.Set("client_secret", s.TwitchClientSecret)
-	request, err := http.NewRequest(http.MethodPost, tokenURL, strings.NewReader(data.Encode()))
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, r.config.TokenURL, strings.NewReader(data.Encode()))
 	if err != nil {
-		log.Fatalf("failed to make refresh token request: %s", err)
+		return 0, fmt.Errorf("failed to make refresh token request: %s", err)
 	}
 	request.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 
-	client := &http.Client{}
-	response, err := client.Do(request)
+	body, err := r.do(request)
 	if err != nil {
-		log.Fatalf("failed to do refresh token request: %s", err)
-		// TODO: retry
+		return 0, fmt.Errorf("failed to do refresh token request: %w", err)
 	}
-	if response.StatusCode != http.StatusOK {
-		log.Fatalf("failed to do refresh token request: %s", response.Status)
+	responseStruct := tokenRefreshResponse{}
+	err = json.Unmarshal(body, &responseStruct)
+	if err != nil {
+		return 0, fmt.Errorf("failed to unmarshal token request response: %s", err)
+	}
+	if responseStruct.AccessToken == "" {
+		return 0, errors.New("token request response didn't contain an access token")
 	}
 
-	body, err := ioutil.ReadAll(response.Body)
+	// Twitch may hand out a new refresh token, after which the old one stops working
+	err = r.credentials.Rotate(responseStruct.AccessToken, responseStruct.RefreshToken)
 	if err != nil {
-		log.Fatalf("failed to read token request response: %s", err)
+		log.Errorf("failed to persist rotated tokens: %s", err)
 	}
-	responseStruct := tokenRefreshResponse{}
-	err = json.Unmarshal(body, &responseStruct)
+
+	expiresIn := time.Duration(responseStruct.ExpiresIn) * time.Second
+	v, err := r.Validate(ctx, responseStruct.AccessToken)
 	if err != nil {
-		log.Fatalf("failed to unmarshal token request response: %s", err)
+		log.Warnf("failed to validate refreshed token: %s", err)
+		return expiresIn, nil
 	}
+	return time.Duration(v.ExpiresIn) * time.Second, nil
+}
 
-	s.TwitchAccessToken = responseStruct.AccessToken
+// Validate checks an access token against Twitch, which also reports how long it stays valid
+func (r *Refresher) Validate(ctx context.Context, accessToken string) (*ValidateResponse, error) {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, r.config.ValidateURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Add("Authorization", fmt.Sprintf("OAuth %s", accessToken))
 
-	marshalledSecrets, err := json.Marshal(s)
+	body, err := r.do(request)
 	if err != nil {
-		log.Errorf("failed to marshal secrets: %s", err)
+		return nil, err
 	}
-	err = ioutil.WriteFile("./secrets.json", marshalledSecrets, 0644)
+	v := &ValidateResponse{}
+	err = json.Unmarshal(body, v)
 	if err != nil {
-		log.Errorf("failed to write secrets: %s", err)
+		return nil, err
+	}
+	return v, nil
+}
+
+// do sends request and returns the body of a 200 response.
+// Client errors other than rate limits are wrapped in errPermanent.
+func (r *Refresher) do(request *http.Request) ([]byte, error) {
+	response, err := r.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode == http.StatusOK {
+		return body, nil
+	}
+	if response.StatusCode >= 400 && response.StatusCode < 500 && response.StatusCode != http.StatusTooManyRequests {
+		return nil, fmt.Errorf("%s: %s: %w", response.Status, body, errPermanent)
 	}
+	return nil, fmt.Errorf("%s: %s", response.Status, body)
 }
@@ -0,0 +1,162 @@
+package renewusertoken
+
+import (
+	"context"
+	"harubot/clock"
+	"harubot/credentials"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRefresher_Refresh(t *testing.T) {
+	tests := []struct {
+		name          string
+		tokenStatuses []int // status of each successive token request, the last one repeats
+		wantErr       bool
+		wantRequests  int
+		wantExpiresIn time.Duration
+		rotateRefresh bool // whether the token endpoint hands out a new refresh token
+	}{
+		{
+			name:          "succeeds right away",
+			tokenStatuses: []int{http.StatusOK},
+			wantRequests:  1,
+			wantExpiresIn: time.Hour,
+		},
+		{
+			name:          "keeps rotated refresh token",
+			tokenStatuses: []int{http.StatusOK},
+			wantRequests:  1,
+			wantExpiresIn: time.Hour,
+			rotateRefresh: true,
+		},
+		{
+			name:          "retries server errors",
+			tokenStatuses: []int{http.StatusBadGateway, http.StatusTooManyRequests, http.StatusOK},
+			wantRequests:  3,
+			wantExpiresIn: time.Hour,
+		},
+		{
+			name:          "doesn't retry an invalid refresh token",
+			tokenStatuses: []int{http.StatusBadRequest},
+			wantErr:       true,
+			wantRequests:  1,
+		},
+		{
+			name:          "gives up after max attempts",
+			tokenStatuses: []int{http.StatusInternalServerError},
+			wantErr:       true,
+			wantRequests:  3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			mux := http.NewServeMux()
+			mux.HandleFunc("/oauth2/token", func(w http.ResponseWriter, r *http.Request) {
+				status := tt.tokenStatuses[len(tt.tokenStatuses)-1]
+				if requests < len(tt.tokenStatuses) {
+					status = tt.tokenStatuses[requests]
+				}
+				requests++
+				if r.FormValue("refresh_token") != "refresh-token" {
+					status = http.StatusBadRequest
+				}
+				w.WriteHeader(status)
+				if status == http.StatusOK && tt.rotateRefresh {
+					w.Write([]byte(`{"access_token":"new-access-token","refresh_token":"new-refresh-token","expires_in":14000}`))
+				} else if status == http.StatusOK {
+					w.Write([]byte(`{"access_token":"new-access-token","expires_in":14000}`))
+				}
+			})
+			mux.HandleFunc("/oauth2/validate", func(w http.ResponseWriter, r *http.Request) {
+				if r.Header.Get("Authorization") != "OAuth new-access-token" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				w.Write([]byte(`{"login":"haruiswaifu","expires_in":3600}`))
+			})
+			server := httptest.NewServer(mux)
+			defer server.Close()
+
+			secretsPath := filepath.Join(t.TempDir(), "secrets.json")
+			provider := credentials.NewProvider(credentials.Secrets{
+				TwitchAccessToken:  "old-access-token",
+				TwitchRefreshToken: "refresh-token",
+				TwitchClientID:     "client-id",
+				TwitchClientSecret: "client-secret",
+			}, credentials.FileStore{Path: secretsPath})
+			rotations := 0
+			provider.Subscribe(func(credentials.Secrets) {
+				rotations++
+			})
+			r := NewRefresher(Config{
+				TokenURL:       server.URL + "/oauth2/token",
+				ValidateURL:    server.URL + "/oauth2/validate",
+				MaxAttempts:    3,
+				InitialBackoff: time.Millisecond,
+				MaxBackoff:     time.Millisecond,
+			}, provider, clock.New())
+
+			expiresIn, err := r.refreshWithRetries(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("refreshWithRetries() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("token requests = %d, want %d", requests, tt.wantRequests)
+			}
+			if expiresIn != tt.wantExpiresIn {
+				t.Errorf("refreshWithRetries() = %s, want %s", expiresIn, tt.wantExpiresIn)
+			}
+			if tt.wantErr {
+				if rotations != 0 {
+					t.Errorf("subscribers were notified %d times after a failed refresh", rotations)
+				}
+				return
+			}
+			if rotations != 1 {
+				t.Errorf("subscribers were notified %d times, want 1", rotations)
+			}
+			persisted, err := credentials.FileStore{Path: secretsPath}.Load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			wantRefreshToken := "refresh-token"
+			if tt.rotateRefresh {
+				wantRefreshToken = "new-refresh-token"
+			}
+			for _, s := range []credentials.Secrets{provider.Get(), persisted} {
+				if s.TwitchAccessToken != "new-access-token" {
+					t.Errorf("access token = %q, want %q", s.TwitchAccessToken, "new-access-token")
+				}
+				if s.TwitchRefreshToken != wantRefreshToken {
+					t.Errorf("refresh token = %q, want %q", s.TwitchRefreshToken, wantRefreshToken)
+				}
+			}
+		})
+	}
+}
+
+func TestRefresher_nextRefresh(t *testing.T) {
+	r := NewRefresher(Config{MaxInterval: time.Hour}, credentials.NewProvider(credentials.Secrets{}, nil), clock.New())
+	tests := []struct {
+		expiresIn time.Duration
+		want      time.Duration
+	}{
+		{expiresIn: 4 * time.Hour, want: time.Hour},
+		{expiresIn: 30 * time.Minute, want: 20 * time.Minute},
+		{expiresIn: 11 * time.Minute, want: time.Minute},
+		{expiresIn: 5 * time.Minute, want: time.Minute},
+		{expiresIn: time.Second, want: time.Minute},
+		{expiresIn: 0, want: time.Hour},
+		{expiresIn: -time.Second, want: time.Hour},
+	}
+	for _, tt := range tests {
+		if got := r.nextRefresh(tt.expiresIn); got != tt.want {
+			t.Errorf("nextRefresh(%s) = %s, want %s", tt.expiresIn, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	chatlog "harubot/chat-log"
+	"harubot/clock"
+	"harubot/emotes"
+	personalmessagequeue "harubot/personal-message-queue"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// replayClient stands in for the IRC client during a replay, printing what the bot would have said
+type replayClient struct {
+	out            io.Writer
+	clock          clock.Clock
+	usersByChannel map[string]map[string]bool
+	lock           sync.Mutex
+}
+
+func newReplayClient(out io.Writer, clk clock.Clock) *replayClient {
+	return &replayClient{
+		out:            out,
+		clock:          clk,
+		usersByChannel: map[string]map[string]bool{},
+	}
+}
+
+func (rc *replayClient) Say(channel, message string) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	fmt.Fprintf(rc.out, "%s #%s: %s\n", rc.clock.Now().Format(time.RFC3339), channel, message)
+}
+
+func (rc *replayClient) Whisper(username, message string) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	fmt.Fprintf(rc.out, "%s whisper to %s: %s\n", rc.clock.Now().Format(time.RFC3339), username, message)
+}
+
+func (rc *replayClient) Join(channels ...string) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	for _, channel := range channels {
+		fmt.Fprintf(rc.out, "%s joined #%s\n", rc.clock.Now().Format(time.RFC3339), channel)
+	}
+}
+
+func (rc *replayClient) Depart(channel string) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	fmt.Fprintf(rc.out, "%s left #%s\n", rc.clock.Now().Format(time.RFC3339), channel)
+}
+
+func (rc *replayClient) Userlist(channel string) ([]string, error) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+	users, ok := rc.usersByChannel[channel]
+	if !ok {
+		return nil, fmt.Errorf("could not find userlist for channel '%s' in replay", channel)
+	}
+	userlist := make([]string, 0, len(users))
+	for user := range users {
+		userlist = append(userlist, user)
+	}
+	return userlist, nil
+}
+
+// observe keeps the replayed userlists up to date with a recorded message
+func (rc *replayClient) observe(message twitchirc.Message) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	switch m := message.(type) {
+	case *twitchirc.UserJoinMessage:
+		rc.addUser(m.Channel, m.User)
+	case *twitchirc.UserPartMessage:
+		delete(rc.usersByChannel[m.Channel], m.User)
+	case *twitchirc.PrivateMessage:
+		rc.addUser(m.Channel, m.User.Name)
+	}
+}
+
+func (rc *replayClient) addUser(channel string, user string) {
+	if _, ok := rc.usersByChannel[channel]; !ok {
+		rc.usersByChannel[channel] = map[string]bool{}
+	}
+	rc.usersByChannel[channel][user] = true
+}
+
+func readChatLogs(paths []string) ([]chatlog.Line, error) {
+	lines := []chatlog.Line{}
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		err = chatlog.ReadLines(file, func(l chatlog.Line) error {
+			lines = append(lines, l)
+			return nil
+		})
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %s", path, err)
+		}
+	}
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].Time.Before(lines[j].Time)
+	})
+	return lines, nil
+}
+
+// runReplay feeds recorded chat logs through the bot's message handling and prints what it would have said
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	envPath := flags.String("env", "./env.json", "path to the environment variables")
+	snapshotPath := flags.String("emotes", "", "path to an emote snapshot written by the bot")
+	speed := flags.Float64("speed", 0, "playback speed relative to real time, 0 replays as fast as possible in virtual time")
+	seed := flags.Int64("seed", 1, "seed of the autoreply delays and templates, the same seed replays the same replies")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *snapshotPath == "" {
+		return errors.New("an emote snapshot is required, set -emotes")
+	}
+	if flags.NArg() == 0 {
+		return errors.New("no chat logs given")
+	}
+
+	e := &environmentVariables{}
+	err = readJSON(*envPath, e)
+	if err != nil {
+		return fmt.Errorf("failed to read environment variables: %s", err)
+	}
+	lines, err := readChatLogs(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+
+	// everything in the bot sees the recorded time, so cooldowns and rate limits behave as they did live
+	clk := clock.NewVirtual(lines[0].Time)
+	emoteCache, err := emotes.NewCacheFromSnapshot(*snapshotPath, clk)
+	if err != nil {
+		return fmt.Errorf("failed to read emote snapshot: %s", err)
+	}
+	client := newReplayClient(os.Stdout, clk)
+	pmq := personalmessagequeue.NewPersonalMessageQueue(e.PersonalMessageQueueCapacity, clk)
+	state, err := newState(context.Background(), client, emoteCache, pmq, clk, e)
+	if err != nil {
+		return fmt.Errorf("failed to set up features: %s", err)
+	}
+	state.random = rand.New(rand.NewSource(*seed))
+
+	previousTime := lines[0].Time
+	for _, l := range lines {
+		if *speed > 0 {
+			time.Sleep(time.Duration(float64(l.Time.Sub(previousTime)) / *speed))
+		}
+		previousTime = l.Time
+		advance(state, clk, l.Time)
+
+		message := twitchirc.ParseMessage(l.Raw)
+		client.observe(message)
+		switch m := message.(type) {
+		case *twitchirc.RoomStateMessage:
+			state.roomStates.Update(*m)
+			state.features.RoomState(*m)
+		case *twitchirc.UserStateMessage:
+			state.userStates.Update(m.Channel, m.User.Badges)
+		case *twitchirc.ClearChatMessage:
+			state.onClearChat(*m)
+		case *twitchirc.ClearMessage:
+			state.onClearMessage(*m)
+		case *twitchirc.NoticeMessage:
+			state.onNotice(*m)
+		}
+		m, ok := message.(*twitchirc.PrivateMessage)
+		if !ok {
+			continue
+		}
+		state.addChannel(m.Channel)
+		state.onPrivateMessage(*m)
+	}
+
+	// skip ahead in virtual time until autoreplies that are still waiting have been sent
+	for {
+		settle(state, clk)
+		if !clk.AdvanceToNextWaiter() {
+			break
+		}
+	}
+	state.outgoing.Wait()
+	return nil
+}
+
+// advance moves clk to t one deadline at a time, letting the autoreplies due at each run before moving on,
+// so every replay sends them at the same virtual time and in the same order relative to the chat
+func advance(state *state, clk *clock.Virtual, t time.Time) {
+	for {
+		settle(state, clk)
+		next, ok := clk.NextDeadline()
+		if !ok || next.After(t) {
+			break
+		}
+		clk.Set(next)
+	}
+	clk.Set(t)
+}
+
+// settle waits until every pending autoreply was sent or sleeps on clk again, e.g. until slow mode allows it
+func settle(state *state, clk *clock.Virtual) {
+	for clk.Waiters() < state.pendingReplyCount() {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,186 @@
+package replystrategy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Strategies decide what an autoreply says
+const (
+	StrategyMirror       = "mirror"        // the emotes of the message
+	StrategyTemplate     = "template"      // one of the templates, picked by weight
+	StrategyPopularEmote = "popular-emote" // the emote used most in the channel lately
+	StrategySilent       = "silent"        // nothing at all
+)
+
+const maxObservedUses = 10000 // per channel, before older uses start to count less
+
+// Template is a reply with {user}, {channel}, {time} and {emotes} placeholders
+type Template struct {
+	Text   string `json:"text"`
+	Weight int    `json:"weight"` // defaults to 1
+}
+
+type Config struct {
+	Strategy  string     `json:"strategy"` // defaults to StrategyMirror
+	Templates []Template `json:"templates"`
+	Fallback  string     `json:"fallback"` // used when Strategy has nothing to say, e.g. StrategyMirror for a message without emotes, defaults to StrategySilent
+}
+
+func (c Config) Validate() error {
+	for _, strategy := range []string{c.Strategy, c.Fallback} {
+		switch strategy {
+		case "", StrategyMirror, StrategyPopularEmote, StrategySilent:
+		case StrategyTemplate:
+			if len(c.Templates) == 0 {
+				return fmt.Errorf("strategy %s needs templates", StrategyTemplate)
+			}
+		default:
+			return fmt.Errorf("unknown strategy %q", strategy)
+		}
+	}
+	for _, t := range c.Templates {
+		if t.Weight < 0 {
+			return fmt.Errorf("template %q has a negative weight", t.Text)
+		}
+	}
+	return nil
+}
+
+// IsSilent tells whether replies would never say anything
+func (c Config) IsSilent() bool {
+	return c.Strategy == StrategySilent && (c.Fallback == "" || c.Fallback == StrategySilent)
+}
+
+// Context is what a reply can be made of
+type Context struct {
+	User         string // display name of who mentioned the bot
+	Channel      string
+	Time         time.Time
+	Emotes       string // of the message that mentioned the bot
+	PopularEmote string // empty if none is known
+}
+
+// Reply returns the text of the reply, empty if there's nothing to say, and whether to mention the user before it.
+// intn picks the template, e.g. rand.Intn.
+func (c Config) Reply(ctx Context, intn func(n int) int) (string, bool) {
+	strategy := c.Strategy
+	if strategy == "" {
+		strategy = StrategyMirror
+	}
+	text, mention := reply(strategy, c.Templates, ctx, intn)
+	if text == "" {
+		return reply(c.Fallback, c.Templates, ctx, intn)
+	}
+	return text, mention
+}
+
+func reply(strategy string, templates []Template, ctx Context, intn func(n int) int) (string, bool) {
+	switch strategy {
+	case StrategyMirror:
+		return ctx.Emotes, ctx.Emotes != ""
+	case StrategyPopularEmote:
+		return ctx.PopularEmote, ctx.PopularEmote != ""
+	case StrategyTemplate:
+		return fill(pick(templates, intn), ctx), false
+	}
+	return "", false
+}
+
+func pick(templates []Template, intn func(n int) int) string {
+	total := 0
+	for _, t := range templates {
+		total += weight(t)
+	}
+	if total == 0 {
+		return ""
+	}
+	n := intn(total)
+	for _, t := range templates {
+		n -= weight(t)
+		if n < 0 {
+			return t.Text
+		}
+	}
+	return ""
+}
+
+func weight(t Template) int {
+	if t.Weight == 0 {
+		return 1
+	}
+	return t.Weight
+}
+
+func fill(template string, ctx Context) string {
+	return strings.TrimSpace(strings.NewReplacer(
+		"{user}", ctx.User,
+		"{channel}", ctx.Channel,
+		"{time}", ctx.Time.Format("15:04"),
+		"{emotes}", ctx.Emotes,
+	).Replace(template))
+}
+
+// Popularity counts how often emotes are used in every channel, older uses fading out over time
+type Popularity struct {
+	uses  map[string]map[string]int
+	total map[string]int
+	lock  sync.Mutex
+}
+
+func NewPopularity() *Popularity {
+	return &Popularity{
+		uses:  map[string]map[string]int{},
+		total: map[string]int{},
+	}
+}
+
+// Observe counts the emotes of a message in channel
+func (p *Popularity) Observe(channel string, emotes []string) {
+	if len(emotes) == 0 {
+		return
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	uses, ok := p.uses[channel]
+	if !ok {
+		uses = map[string]int{}
+		p.uses[channel] = uses
+	}
+	for _, emote := range emotes {
+		uses[emote]++
+	}
+	p.total[channel] += len(emotes)
+	if p.total[channel] > maxObservedUses {
+		p.total[channel] = 0
+		for emote, count := range uses {
+			if count/2 == 0 {
+				delete(uses, emote)
+				continue
+			}
+			uses[emote] = count / 2
+			p.total[channel] += count / 2
+		}
+	}
+}
+
+// Most returns the most used emote in channel, or an empty string if none was used yet
+func (p *Popularity) Most(channel string) string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	emotes := make([]string, 0, len(p.uses[channel]))
+	for emote := range p.uses[channel] {
+		emotes = append(emotes, emote)
+	}
+	sort.Strings(emotes) // ties go to the first emote alphabetically
+	most, mostUses := "", 0
+	for _, emote := range emotes {
+		if uses := p.uses[channel][emote]; uses > mostUses {
+			most, mostUses = emote, uses
+		}
+	}
+	return most
+}
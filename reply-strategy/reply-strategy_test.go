@@ -0,0 +1,63 @@
+package replystrategy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConfig_Reply(t *testing.T) {
+	ctx := Context{
+		User:         "Forsen",
+		Channel:      "xqc",
+		Time:         time.Date(2023, 3, 1, 21, 5, 0, 0, time.UTC),
+		Emotes:       "KEKW",
+		PopularEmote: "OMEGALUL",
+	}
+	noEmotes := ctx
+	noEmotes.Emotes = ""
+	templates := []Template{
+		{Text: "hi {user}", Weight: 3},
+		{Text: "{emotes} in {channel} at {time}"},
+	}
+	tests := []struct {
+		name        string
+		config      Config
+		ctx         Context
+		pick        int
+		want        string
+		wantMention bool
+	}{
+		{"default", Config{}, ctx, 0, "KEKW", true},
+		{"mirror without emotes", Config{Strategy: StrategyMirror}, noEmotes, 0, "", false},
+		{"mirror falling back", Config{Strategy: StrategyMirror, Fallback: StrategyPopularEmote}, noEmotes, 0, "OMEGALUL", true},
+		{"heavier template", Config{Strategy: StrategyTemplate, Templates: templates}, ctx, 2, "hi Forsen", false},
+		{"lighter template", Config{Strategy: StrategyTemplate, Templates: templates}, ctx, 3, "KEKW in xqc at 21:05", false},
+		{"silent", Config{Strategy: StrategySilent}, ctx, 0, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if err != nil {
+				t.Fatalf("Validate() failed: %s", err)
+			}
+			got, mention := tt.config.Reply(tt.ctx, func(n int) int { return tt.pick })
+			if got != tt.want || mention != tt.wantMention {
+				t.Errorf("Reply() = %q, %v, want %q, %v", got, mention, tt.want, tt.wantMention)
+			}
+		})
+	}
+}
+
+func TestPopularity_Most(t *testing.T) {
+	p := NewPopularity()
+	p.Observe("xqc", []string{"KEKW", "OMEGALUL", "KEKW"})
+	p.Observe("xqc", []string{"OMEGALUL"})
+	p.Observe("xqc", []string{"Kappa"})
+	p.Observe("forsen", []string{"forsenE", "forsenE", "forsenE"})
+	if got := p.Most("xqc"); got != "KEKW" {
+		t.Errorf("Most(xqc) = %q, want KEKW, which ties with OMEGALUL but comes first", got)
+	}
+	if got := p.Most("pokimane"); got != "" {
+		t.Errorf("Most(pokimane) = %q, want none", got)
+	}
+}
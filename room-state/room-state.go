@@ -0,0 +1,149 @@
+package roomstate
+
+import (
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"harubot/clock"
+	"sync"
+	"time"
+)
+
+// R9KMinimumLength is the length from which messages have to be unique in unique-chat mode
+const R9KMinimumLength = 10
+
+// uniqueSuffix doesn't show in chat, but makes a sentence differ from the ones it repeats
+const uniqueSuffix = " \U000E0000"
+
+// FollowersOnlyNotices are the msg-ids of NOTICEs Twitch rejects messages with when the bot doesn't follow long enough
+var FollowersOnlyNotices = map[string]bool{
+	"msg_followersonly":          true,
+	"msg_followersonly_zero":     true,
+	"msg_followersonly_followed": true,
+}
+
+// Modes are the chat restrictions of a channel
+type Modes struct {
+	EmoteOnly     bool `json:"emote-only"`
+	FollowersOnly int  `json:"followers-only"` // minutes users have to follow for, -1 if disabled
+	NotFollowing  bool `json:"not-following"`  // Twitch rejected a message because the bot doesn't follow long enough
+	R9K           bool `json:"r9k"`
+	Slow          int  `json:"slow"` // seconds between messages of a user
+	SubsOnly      bool `json:"subs-only"`
+}
+
+var unrestricted = Modes{FollowersOnly: -1}
+
+// Allows tells whether sentence may be sent in these modes, given whether it consists of emotes only.
+// In unique-chat mode, sentences long enough to be checked are made unique with Unique, which emote-only mode doesn't allow.
+func (m Modes) Allows(sentence string, onlyEmotes bool) bool {
+	if m.EmoteOnly && !onlyEmotes {
+		return false
+	}
+	if m.R9K && m.EmoteOnly && len([]rune(sentence)) >= R9KMinimumLength {
+		return false
+	}
+	return true
+}
+
+// FollowersOnlyBlocks tells whether followers-only mode keeps the bot from talking
+func (m Modes) FollowersOnlyBlocks() bool {
+	return m.FollowersOnly >= 0 && m.NotFollowing
+}
+
+// Unique returns sentence as it can be sent in unique-chat mode, with an invisible suffix if it's long enough to be checked
+func (m Modes) Unique(sentence string) string {
+	if !m.R9K || m.EmoteOnly || len([]rune(sentence)) < R9KMinimumLength {
+		return sentence
+	}
+	return sentence + uniqueSuffix
+}
+
+// Tracker keeps the chat modes of every channel from ROOMSTATE messages, and when the bot last talked for slow mode
+type Tracker struct {
+	modesByChannel map[string]Modes
+	lastSent       map[string]time.Time
+	clock          clock.Clock
+	lock           sync.Mutex
+}
+
+func NewTracker(clk clock.Clock) *Tracker {
+	return &Tracker{
+		modesByChannel: map[string]Modes{},
+		lastSent:       map[string]time.Time{},
+		clock:          clk,
+	}
+}
+
+// Update applies a ROOMSTATE, which after joining has every mode and afterwards only the ones that changed
+func (t *Tracker) Update(m twitchirc.RoomStateMessage) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	modes, ok := t.modesByChannel[m.Channel]
+	if !ok {
+		modes = unrestricted
+	}
+	for tag, value := range m.State {
+		switch tag {
+		case "emote-only":
+			modes.EmoteOnly = value == 1
+		case "followers-only":
+			if value != modes.FollowersOnly {
+				modes.NotFollowing = false // worth trying again with different requirements
+			}
+			modes.FollowersOnly = value
+		case "r9k":
+			modes.R9K = value == 1
+		case "slow":
+			modes.Slow = value
+		case "subs-only":
+			modes.SubsOnly = value == 1
+		}
+	}
+	t.modesByChannel[m.Channel] = modes
+}
+
+// Get returns the modes of channel, unrestricted until its ROOMSTATE arrived
+func (t *Tracker) Get(channel string) Modes {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	modes, ok := t.modesByChannel[channel]
+	if !ok {
+		return unrestricted
+	}
+	return modes
+}
+
+// NotFollowing remembers that Twitch rejected a message of the bot in channel because of followers-only mode,
+// until the mode changes
+func (t *Tracker) NotFollowing(channel string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	modes, ok := t.modesByChannel[channel]
+	if !ok {
+		modes = unrestricted
+	}
+	modes.NotFollowing = true
+	t.modesByChannel[channel] = modes
+}
+
+// Sent remembers that the bot just talked in channel
+func (t *Tracker) Sent(channel string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.lastSent[channel] = t.clock.Now()
+}
+
+// SlowModeWait returns how long the bot has to wait before it may talk in channel again
+func (t *Tracker) SlowModeWait(channel string) time.Duration {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	modes, ok := t.modesByChannel[channel]
+	last, sent := t.lastSent[channel]
+	if !ok || !sent || modes.Slow <= 0 {
+		return 0
+	}
+	wait := time.Duration(modes.Slow)*time.Second - t.clock.Since(last)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
@@ -0,0 +1,104 @@
+package roomstate
+
+import (
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"harubot/clock"
+	"testing"
+	"time"
+)
+
+func TestTracker_Update(t *testing.T) {
+	tr := NewTracker(clock.NewVirtual(time.Now()))
+	tr.Update(twitchirc.RoomStateMessage{Channel: "xqc", State: map[string]int{"emote-only": 0, "followers-only": -1, "r9k": 0, "slow": 0, "subs-only": 0}})
+	tr.Update(twitchirc.RoomStateMessage{Channel: "xqc", State: map[string]int{"slow": 30}})
+	tr.Update(twitchirc.RoomStateMessage{Channel: "xqc", State: map[string]int{"emote-only": 1}})
+
+	want := Modes{EmoteOnly: true, FollowersOnly: -1, Slow: 30}
+	if got := tr.Get("xqc"); got != want {
+		t.Errorf("Get() = %+v, want %+v", got, want)
+	}
+	if got := tr.Get("forsen"); got != unrestricted {
+		t.Errorf("Get() of unknown channel = %+v, want %+v", got, unrestricted)
+	}
+}
+
+func TestModes_Allows(t *testing.T) {
+	tests := []struct {
+		name       string
+		modes      Modes
+		sentence   string
+		onlyEmotes bool
+		want       bool
+	}{
+		{"unrestricted", unrestricted, "hello chat", false, true},
+		{"emote-only with text", Modes{EmoteOnly: true}, "hello KEKW", false, false},
+		{"emote-only with emotes", Modes{EmoteOnly: true}, "KEKW KEKW", true, true},
+		{"r9k short", Modes{R9K: true}, "KEKW", true, true},
+		{"r9k long", Modes{R9K: true}, "OMEGALUL OMEGALUL", true, true},
+		{"r9k long in emote-only", Modes{R9K: true, EmoteOnly: true}, "OMEGALUL OMEGALUL", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.modes.Allows(tt.sentence, tt.onlyEmotes); got != tt.want {
+				t.Errorf("Allows() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTracker_SlowModeWait(t *testing.T) {
+	clk := clock.NewVirtual(time.Now())
+	tr := NewTracker(clk)
+	tr.Update(twitchirc.RoomStateMessage{Channel: "xqc", State: map[string]int{"slow": 30}})
+	if got := tr.SlowModeWait("xqc"); got != 0 {
+		t.Errorf("SlowModeWait() before sending = %s, want 0", got)
+	}
+	tr.Sent("xqc")
+	clk.Advance(10 * time.Second)
+	if got := tr.SlowModeWait("xqc"); got != 20*time.Second {
+		t.Errorf("SlowModeWait() = %s, want 20s", got)
+	}
+	clk.Advance(time.Minute)
+	if got := tr.SlowModeWait("xqc"); got != 0 {
+		t.Errorf("SlowModeWait() after interval = %s, want 0", got)
+	}
+}
+
+func TestModes_Unique(t *testing.T) {
+	tests := []struct {
+		name     string
+		modes    Modes
+		sentence string
+		want     string
+	}{
+		{"unrestricted", unrestricted, "OMEGALUL OMEGALUL", "OMEGALUL OMEGALUL"},
+		{"r9k short", Modes{R9K: true}, "KEKW", "KEKW"},
+		{"r9k long", Modes{R9K: true}, "OMEGALUL OMEGALUL", "OMEGALUL OMEGALUL" + uniqueSuffix},
+		{"r9k in emote-only", Modes{R9K: true, EmoteOnly: true}, "OMEGALUL OMEGALUL", "OMEGALUL OMEGALUL"},
+	}
+	for _, tt := range tests {
+		if got := tt.modes.Unique(tt.sentence); got != tt.want {
+			t.Errorf("%s: Unique(%q) = %q, want %q", tt.name, tt.sentence, got, tt.want)
+		}
+	}
+}
+
+func TestTracker_NotFollowing(t *testing.T) {
+	tr := NewTracker(clock.NewVirtual(time.Now()))
+	tr.Update(twitchirc.RoomStateMessage{Channel: "xqc", State: map[string]int{"followers-only": 10}})
+	if tr.Get("xqc").FollowersOnlyBlocks() {
+		t.Error("FollowersOnlyBlocks() before any rejection = true, want false")
+	}
+	tr.NotFollowing("xqc")
+	if !tr.Get("xqc").FollowersOnlyBlocks() {
+		t.Error("FollowersOnlyBlocks() after a rejection = false, want true")
+	}
+	tr.Update(twitchirc.RoomStateMessage{Channel: "xqc", State: map[string]int{"slow": 30}})
+	if !tr.Get("xqc").FollowersOnlyBlocks() {
+		t.Error("FollowersOnlyBlocks() after another mode changed = false, want true")
+	}
+	tr.Update(twitchirc.RoomStateMessage{Channel: "xqc", State: map[string]int{"followers-only": -1}})
+	if tr.Get("xqc").FollowersOnlyBlocks() {
+		t.Error("FollowersOnlyBlocks() after followers-only mode ended = true, want false")
+	}
+}
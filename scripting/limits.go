@@ -0,0 +1,444 @@
+package scripting
+
+import (
+	"fmt"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"math"
+	"runtime/metrics"
+	"strings"
+)
+
+// allocationCheckSteps is how many steps a call takes between two measurements of what it allocated,
+// since a measurement costs about as much as a few hundred steps
+const allocationCheckSteps = 16
+
+// meterKey is the thread local holding the meter of a call
+const meterKey = "meter"
+
+// meter measures what a call into a script allocates. The runtime only counts the allocations of the whole process,
+// so whatever the rest of the bot allocates during the call is counted as well, which is little next to the limit.
+type meter struct {
+	limit     uint64 // 0 if unlimited
+	start     uint64
+	allocated []metrics.Sample
+}
+
+func newMeter(limit uint64) *meter {
+	m := &meter{
+		limit:     limit,
+		allocated: []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}},
+	}
+	metrics.Read(m.allocated)
+	m.start = m.allocated[0].Value.Uint64()
+	return m
+}
+
+// reserve fails if the call can't allocate size more bytes without exceeding the limit
+func (m *meter) reserve(size uint64) error {
+	if m.limit == 0 {
+		return nil
+	}
+	metrics.Read(m.allocated)
+	if add(m.allocated[0].Value.Uint64()-m.start, size) > m.limit {
+		return fmt.Errorf("call would allocate more than %d bytes", m.limit)
+	}
+	return nil
+}
+
+func reserve(thread *starlark.Thread, size uint64) error {
+	m, ok := thread.Local(meterKey).(*meter)
+	if !ok {
+		return nil
+	}
+	return m.reserve(size)
+}
+
+// grown are the operators and string methods that can build a value much larger than their operands in a single step,
+// which the steps and the meter can't stop in time. guard routes them through the builtins of the same names.
+var (
+	grownOperators = map[syntax.Token]string{syntax.STAR: "$*", syntax.PLUS: "$+", syntax.PERCENT: "$%"}
+	grownAugmented = map[syntax.Token]string{syntax.STAR_EQ: "$*=", syntax.PLUS_EQ: "$+=", syntax.PERCENT_EQ: "$%="}
+	grownMethods   = map[string]string{"join": "$join", "replace": "$replace", "format": "$format"}
+)
+
+// guard rewrites the syntax tree of a script so that x * y becomes $*(x, y), x *= y becomes x *= $*=(x, y),
+// s.join(l) becomes $join(s, l) and so on. Names starting with $ can't be written in a script, so it can't shadow them.
+func guard(f *syntax.File) error {
+	var err error
+	syntax.Walk(f, func(n syntax.Node) bool {
+		switch n := n.(type) {
+		case *syntax.AssignStmt:
+			if name, ok := grownAugmented[n.Op]; ok {
+				if hasCall(n.LHS) {
+					err = fmt.Errorf("%s: the target of %s can't contain a call", n.OpPos, n.Op)
+					return false
+				}
+				n.RHS = guardedCall(name, n.OpPos, n.LHS, n.RHS)
+			}
+			n.RHS = guarded(n.RHS)
+		case *syntax.ExprStmt:
+			n.X = guarded(n.X)
+		case *syntax.IfStmt:
+			n.Cond = guarded(n.Cond)
+		case *syntax.WhileStmt:
+			n.Cond = guarded(n.Cond)
+		case *syntax.ForStmt:
+			n.X = guarded(n.X)
+		case *syntax.ReturnStmt:
+			if n.Result != nil {
+				n.Result = guarded(n.Result)
+			}
+		case *syntax.ForClause:
+			n.X = guarded(n.X)
+		case *syntax.IfClause:
+			n.Cond = guarded(n.Cond)
+		case *syntax.Comprehension:
+			n.Body = guarded(n.Body)
+		case *syntax.CondExpr:
+			n.Cond, n.True, n.False = guarded(n.Cond), guarded(n.True), guarded(n.False)
+		case *syntax.DictEntry:
+			n.Key, n.Value = guarded(n.Key), guarded(n.Value)
+		case *syntax.DotExpr:
+			n.X = guarded(n.X)
+		case *syntax.IndexExpr:
+			n.X, n.Y = guarded(n.X), guarded(n.Y)
+		case *syntax.SliceExpr:
+			n.X, n.Lo, n.Hi, n.Step = guarded(n.X), guarded(n.Lo), guarded(n.Hi), guarded(n.Step)
+		case *syntax.LambdaExpr:
+			n.Body = guarded(n.Body)
+		case *syntax.ParenExpr:
+			n.X = guarded(n.X)
+		case *syntax.UnaryExpr:
+			n.X = guarded(n.X)
+		case *syntax.BinaryExpr:
+			n.X, n.Y = guarded(n.X), guarded(n.Y)
+		case *syntax.ListExpr:
+			for i := range n.List {
+				n.List[i] = guarded(n.List[i])
+			}
+		case *syntax.TupleExpr:
+			for i := range n.List {
+				n.List[i] = guarded(n.List[i])
+			}
+		case *syntax.CallExpr:
+			n.Fn = guarded(n.Fn)
+			for i := range n.Args {
+				n.Args[i] = guarded(n.Args[i])
+			}
+		}
+		return err == nil
+	})
+	return err
+}
+
+// guarded returns the call replacing e if it's one of the grown operators or methods, e itself otherwise
+func guarded(e syntax.Expr) syntax.Expr {
+	switch e := e.(type) {
+	case *syntax.BinaryExpr:
+		if name, ok := grownOperators[e.Op]; ok {
+			return guardedCall(name, e.OpPos, e.X, e.Y)
+		}
+	case *syntax.CallExpr:
+		if dot, ok := e.Fn.(*syntax.DotExpr); ok {
+			if name, ok := grownMethods[dot.Name.Name]; ok {
+				return &syntax.CallExpr{
+					Fn:     &syntax.Ident{NamePos: dot.Dot, Name: name},
+					Lparen: e.Lparen,
+					Args:   append([]syntax.Expr{dot.X}, e.Args...),
+					Rparen: e.Rparen,
+				}
+			}
+		}
+	}
+	return e
+}
+
+func guardedCall(name string, pos syntax.Position, args ...syntax.Expr) *syntax.CallExpr {
+	return &syntax.CallExpr{
+		Fn:     &syntax.Ident{NamePos: pos, Name: name},
+		Lparen: pos,
+		Args:   args,
+		Rparen: pos,
+	}
+}
+
+// hasCall reports whether e calls anything, which would happen twice once x op= y also passes x to its builtin
+func hasCall(e syntax.Expr) bool {
+	found := false
+	syntax.Walk(e, func(n syntax.Node) bool {
+		if _, ok := n.(*syntax.CallExpr); ok {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// guards are the builtins guard routes the grown operators and methods through, together with replacements
+// of the universal builtins that build a value as large as everything their arguments contain
+func guards() starlark.StringDict {
+	d := starlark.StringDict{}
+	for op, name := range grownOperators {
+		d[name] = operatorGuard(name, op)
+	}
+	for op, name := range grownAugmented {
+		d[name] = augmentedGuard(name, op-syntax.PLUS_EQ+syntax.PLUS)
+	}
+	for method, name := range grownMethods {
+		d[name] = methodGuard(name, method)
+	}
+	for _, name := range []string{"list", "tuple", "sorted", "reversed"} {
+		d[name] = universeGuard(name, elementsSize(16))
+	}
+	for _, name := range []string{"enumerate", "zip"} {
+		d[name] = universeGuard(name, elementsSize(80)) // a tuple for every element
+	}
+	for _, name := range []string{"str", "repr", "print"} {
+		d[name] = universeGuard(name, reprsSize)
+	}
+	return d
+}
+
+func operatorGuard(name string, op syntax.Token) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := reserve(thread, resultSize(op, args[0], args[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s %s %s: %s", args[0].Type(), op, args[1].Type(), err)
+		}
+		return starlark.Binary(op, args[0], args[1])
+	})
+}
+
+// augmentedGuard checks x op y before the assignment itself computes it and returns y
+func augmentedGuard(name string, op syntax.Token) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := reserve(thread, resultSize(op, args[0], args[1]))
+		if err != nil {
+			return nil, fmt.Errorf("%s %s= %s: %s", args[0].Type(), op, args[1].Type(), err)
+		}
+		return args[1], nil
+	})
+}
+
+func methodGuard(name string, method string) *starlark.Builtin {
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		recv, ok := args[0].(starlark.HasAttrs)
+		if !ok {
+			return nil, fmt.Errorf("%s has no .%s field or method", args[0].Type(), method)
+		}
+		fn, err := recv.Attr(method)
+		if err != nil {
+			return nil, err
+		}
+		if fn == nil {
+			return nil, fmt.Errorf("%s has no .%s field or method", args[0].Type(), method)
+		}
+		if s, ok := recv.(starlark.String); ok {
+			err = reserve(thread, methodSize(string(s), method, args[1:], kwargs))
+			if err != nil {
+				return nil, fmt.Errorf("%s.%s: %s", s.Type(), method, err)
+			}
+		}
+		return starlark.Call(thread, fn, args[1:], kwargs)
+	})
+}
+
+func universeGuard(name string, size func(args starlark.Tuple, kwargs []starlark.Tuple) uint64) *starlark.Builtin {
+	fn := starlark.Universe[name]
+	return starlark.NewBuiltin(name, func(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+		err := reserve(thread, size(args, kwargs))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		return starlark.Call(thread, fn, args, kwargs)
+	})
+}
+
+// elementsSize estimates what a copy of the elements of every argument of known length takes, at perElement bytes each
+func elementsSize(perElement uint64) func(args starlark.Tuple, kwargs []starlark.Tuple) uint64 {
+	return func(args starlark.Tuple, kwargs []starlark.Tuple) uint64 {
+		size := uint64(0)
+		for _, arg := range args {
+			if n := starlark.Len(arg); n > 0 {
+				size = add(size, mul(uint64(n), perElement))
+			}
+		}
+		return size
+	}
+}
+
+func reprsSize(args starlark.Tuple, kwargs []starlark.Tuple) uint64 {
+	size := uint64(0)
+	memo := map[interface{}]uint64{}
+	for _, arg := range args {
+		if _, ok := arg.(starlark.String); !ok { // str of a string is the string itself
+			size = add(size, reprSize(arg, memo))
+		}
+	}
+	return size
+}
+
+// resultSize estimates the bytes of x op y for the operators that can build large values
+func resultSize(op syntax.Token, x, y starlark.Value) uint64 {
+	switch op {
+	case syntax.STAR:
+		if n, ok := y.(starlark.Int); ok {
+			return repeatedSize(x, n)
+		}
+		if n, ok := x.(starlark.Int); ok {
+			return repeatedSize(y, n)
+		}
+	case syntax.PLUS:
+		return add(sequenceSize(x), sequenceSize(y))
+	case syntax.PERCENT:
+		if format, ok := x.(starlark.String); ok {
+			return formattedSize(string(format), strings.Count(string(format), "%"), reprSize(y, map[interface{}]uint64{}))
+		}
+	}
+	return 0
+}
+
+func repeatedSize(x starlark.Value, n starlark.Int) uint64 {
+	if i, ok := x.(starlark.Int); ok {
+		return uint64(i.BigInt().BitLen()+n.BigInt().BitLen()) / 8
+	}
+	times, ok := n.Uint64()
+	if !ok {
+		if n.Sign() < 0 {
+			return 0
+		}
+		times = math.MaxUint64
+	}
+	return mul(sequenceSize(x), times)
+}
+
+// sequenceSize is what the elements of strings, bytes, lists and tuples take, and 0 for anything else
+func sequenceSize(x starlark.Value) uint64 {
+	switch x := x.(type) {
+	case starlark.String:
+		return uint64(len(x))
+	case starlark.Bytes:
+		return uint64(len(x))
+	case *starlark.List:
+		return mul(uint64(x.Len()), 16)
+	case starlark.Tuple:
+		return mul(uint64(len(x)), 16)
+	}
+	return 0
+}
+
+func methodSize(s string, method string, args starlark.Tuple, kwargs []starlark.Tuple) uint64 {
+	switch method {
+	case "join":
+		if len(args) != 1 {
+			return 0
+		}
+		size := uint64(0)
+		count := uint64(0)
+		iter := starlark.Iterate(args[0])
+		if iter == nil {
+			return 0
+		}
+		defer iter.Done()
+		var x starlark.Value
+		for iter.Next(&x) {
+			size = add(size, sequenceSize(x))
+			count++
+		}
+		return add(size, mul(count, uint64(len(s))))
+	case "replace":
+		if len(args) < 2 {
+			return 0
+		}
+		old, ok1 := starlark.AsString(args[0])
+		new, ok2 := starlark.AsString(args[1])
+		if !ok1 || !ok2 {
+			return 0
+		}
+		return add(uint64(len(s)), mul(uint64(strings.Count(s, old)), uint64(len(new))))
+	case "format":
+		memo := map[interface{}]uint64{}
+		size := reprSize(args, memo)
+		for _, kwarg := range kwargs {
+			size = add(size, reprSize(kwarg[1], memo))
+		}
+		return formattedSize(s, strings.Count(s, "{"), size)
+	}
+	return 0
+}
+
+// formattedSize bounds the result of a format with the given placeholders, each of which may take all arguments
+func formattedSize(format string, placeholders int, argumentsSize uint64) uint64 {
+	return add(uint64(len(format)), mul(uint64(placeholders), argumentsSize))
+}
+
+// reprSize bounds the length of the string form of v. Containers are only measured once in memo,
+// so values referencing the same container over and over, or even themselves, are measured quickly.
+func reprSize(v starlark.Value, memo map[interface{}]uint64) uint64 {
+	var key interface{}
+	switch v := v.(type) {
+	case starlark.String:
+		return add(mul(uint64(len(v)), 4), 2) // escaped, \x00 takes 4 bytes
+	case starlark.Bytes:
+		return add(mul(uint64(len(v)), 4), 3)
+	case starlark.Int:
+		return uint64(v.BigInt().BitLen())/3 + 2
+	case starlark.NoneType, starlark.Bool, starlark.Float:
+		return 32
+	case *store:
+		return reprSize(v.entries, memo)
+	case *starlark.List:
+		key = v
+	case *starlark.Dict:
+		key = v
+	case starlark.Tuple:
+		if len(v) == 0 {
+			return 2
+		}
+		key = tupleKey{&v[0], len(v)}
+	default:
+		return uint64(len(v.String()))
+	}
+	if size, ok := memo[key]; ok {
+		return size
+	}
+	memo[key] = 5 // while measuring its elements, a container referencing itself is printed as [...]
+	size := uint64(2)
+	if d, ok := v.(*starlark.Dict); ok {
+		for _, item := range d.Items() {
+			size = add(size, add(add(reprSize(item[0], memo), reprSize(item[1], memo)), 4))
+		}
+	} else {
+		iter := starlark.Iterate(v)
+		defer iter.Done()
+		var x starlark.Value
+		for iter.Next(&x) {
+			size = add(size, add(reprSize(x, memo), 2))
+		}
+	}
+	memo[key] = size
+	return size
+}
+
+// tupleKey identifies a tuple, which unlike lists and dicts isn't a pointer, by its elements
+type tupleKey struct {
+	first *starlark.Value
+	len   int
+}
+
+// add and mul saturate instead of overflowing, so estimates of huge values stay huge
+
+func add(a, b uint64) uint64 {
+	if a > math.MaxUint64-b {
+		return math.MaxUint64
+	}
+	return a + b
+}
+
+func mul(a, b uint64) uint64 {
+	if b != 0 && a > math.MaxUint64/b {
+		return math.MaxUint64
+	}
+	return a * b
+}
@@ -0,0 +1,417 @@
+package scripting
+
+import (
+	"encoding/json"
+	"fmt"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	log "github.com/sirupsen/logrus"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+	"go.starlark.net/syntax"
+	"harubot/feature"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const Name = "scripts"
+
+const (
+	maxScriptBytes = 64 * 1024
+	maxSayLength   = 500 // the most Twitch allows in one message
+)
+
+type Config struct {
+	Directory             string `json:"directory"`            // every .star file in it is a script
+	MaxSteps              uint64 `json:"max-steps"`            // per call into a script
+	MaxAllocationBytes    uint64 `json:"max-allocation-bytes"` // per call into a script, 0 for no limit
+	TimeoutMilliseconds   int    `json:"timeout-milliseconds"`
+	ReloadIntervalSeconds int    `json:"reload-interval-seconds"` // how often the directory is checked for changed scripts
+}
+
+type script struct {
+	file      string
+	name      string
+	channels  map[string]bool // nil if the script runs in every channel
+	onMessage starlark.Callable
+	store     *store // kept between calls, unlike the frozen globals of the script
+}
+
+// Scripts runs the starlark scripts of a directory on chat messages, reloading them whenever they change
+type Scripts struct {
+	host      feature.Host
+	config    Config
+	scripts   []*script
+	modTimes  map[string]time.Time // of the loaded files
+	lastCheck time.Time
+	lock      sync.Mutex
+}
+
+func New(host feature.Host) feature.Feature {
+	return &Scripts{
+		host: host,
+		config: Config{
+			MaxSteps:              100000,
+			MaxAllocationBytes:    32 * 1024 * 1024,
+			TimeoutMilliseconds:   100,
+			ReloadIntervalSeconds: 5,
+		},
+		modTimes: map[string]time.Time{},
+	}
+}
+
+func (s *Scripts) Name() string {
+	return Name
+}
+
+func (s *Scripts) Configure(config json.RawMessage) error {
+	err := json.Unmarshal(config, &s.config)
+	if err != nil {
+		return err
+	}
+	if s.config.Directory == "" {
+		return nil
+	}
+	return s.Reload()
+}
+
+// Reload loads the scripts that were added or changed since they were last loaded, skipping those that fail to load.
+// Unchanged scripts are kept as they are, together with their stores.
+func (s *Scripts) Reload() error {
+	modTimes, err := s.scan()
+	if err != nil {
+		return err
+	}
+	files := make([]string, 0, len(modTimes))
+	for file := range modTimes {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+
+	s.lock.Lock()
+	unchanged := map[string]*script{}
+	for _, sc := range s.scripts {
+		if loaded, ok := s.modTimes[sc.file]; ok && loaded.Equal(modTimes[sc.file]) {
+			unchanged[sc.file] = sc
+		}
+	}
+	s.lock.Unlock()
+
+	scripts := []*script{}
+	for _, file := range files {
+		if sc, ok := unchanged[file]; ok {
+			scripts = append(scripts, sc)
+			continue
+		}
+		sc, err := s.load(file)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"script": file,
+			}).Errorf("failed to load script: %s", err)
+			continue
+		}
+		scripts = append(scripts, sc)
+	}
+	log.Infof("loaded %d of %d scripts, %d of them unchanged", len(scripts), len(files), len(unchanged))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.scripts = scripts
+	s.modTimes = modTimes
+	return nil
+}
+
+// scan returns the modification times of the scripts in the directory
+func (s *Scripts) scan() (map[string]time.Time, error) {
+	modTimes := map[string]time.Time{}
+	entries, err := os.ReadDir(s.config.Directory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read script directory: %s", err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".star" {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		modTimes[filepath.Join(s.config.Directory, entry.Name())] = info.ModTime()
+	}
+	return modTimes, nil
+}
+
+func (s *Scripts) load(file string) (*script, error) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if len(source) > maxScriptBytes {
+		return nil, fmt.Errorf("script is larger than %d bytes", maxScriptBytes)
+	}
+	sc := &script{
+		file:  file,
+		name:  filepath.Base(file),
+		store: newStore(),
+	}
+	f, err := syntax.Parse(file, source, 0)
+	if err != nil {
+		return nil, err
+	}
+	err = guard(f)
+	if err != nil {
+		return nil, err
+	}
+	builtins := s.builtins(sc)
+	program, err := starlark.FileProgram(f, builtins.Has)
+	if err != nil {
+		return nil, err
+	}
+	thread, done := s.thread(sc)
+	defer done()
+	globals, err := program.Init(thread, builtins)
+	globals.Freeze()
+	if err != nil {
+		return nil, err
+	}
+
+	if channels, ok := globals["channels"]; ok {
+		list, ok := channels.(*starlark.List)
+		if !ok {
+			return nil, fmt.Errorf("channels is a %s, not a list", channels.Type())
+		}
+		sc.channels = map[string]bool{}
+		for i := 0; i < list.Len(); i++ {
+			channel, ok := starlark.AsString(list.Index(i))
+			if !ok {
+				return nil, fmt.Errorf("channels contains %s, not only strings", list.Index(i))
+			}
+			sc.channels[strings.ToLower(channel)] = true
+		}
+	}
+	if onMessage, ok := globals["on_message"]; ok {
+		callable, ok := onMessage.(starlark.Callable)
+		if !ok {
+			return nil, fmt.Errorf("on_message is a %s, not a function", onMessage.Type())
+		}
+		sc.onMessage = callable
+	}
+	return sc, nil
+}
+
+// scriptKey is the thread local holding the script a thread runs
+const scriptKey = "script"
+
+// thread creates a thread for one call into sc, limited in steps, allocations and time until done is called
+func (s *Scripts) thread(sc *script) (thread *starlark.Thread, done func()) {
+	thread = &starlark.Thread{
+		Name: sc.name,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.WithFields(log.Fields{
+				"script": sc.name,
+			}).Info(msg)
+		},
+	}
+	thread.SetLocal(scriptKey, sc)
+	m := newMeter(s.config.MaxAllocationBytes)
+	thread.SetLocal(meterKey, m)
+	// the steps run out every allocationCheckSteps to measure the allocations, until all of them are used up
+	thread.OnMaxSteps = func(thread *starlark.Thread) {
+		if s.config.MaxSteps != 0 && thread.ExecutionSteps() >= s.config.MaxSteps {
+			thread.Cancel("too many steps")
+			return
+		}
+		err := m.reserve(0)
+		if err != nil {
+			thread.Cancel(err.Error())
+			return
+		}
+		thread.SetMaxExecutionSteps(s.nextCheck(thread.ExecutionSteps()))
+	}
+	thread.SetMaxExecutionSteps(s.nextCheck(0))
+	timer := time.AfterFunc(time.Duration(s.config.TimeoutMilliseconds)*time.Millisecond, func() {
+		thread.Cancel("timed out")
+	})
+	return thread, func() { timer.Stop() }
+}
+
+// nextCheck is the step after steps at which a call's allocations are measured next, or it runs out of steps
+func (s *Scripts) nextCheck(steps uint64) uint64 {
+	next := steps + allocationCheckSteps
+	if s.config.MaxSteps != 0 && next > s.config.MaxSteps {
+		return s.config.MaxSteps
+	}
+	return next
+}
+
+// OnTick reloads the scripts once any of them changed, was added or removed
+func (s *Scripts) OnTick(now time.Time) {
+	if s.config.Directory == "" || now.Sub(s.lastCheck) < time.Duration(s.config.ReloadIntervalSeconds)*time.Second {
+		return
+	}
+	s.lastCheck = now
+	modTimes, err := s.scan()
+	if err != nil {
+		log.Errorf("failed to check scripts for changes: %s", err)
+		return
+	}
+	s.lock.Lock()
+	changed := len(modTimes) != len(s.modTimes)
+	for file, modTime := range modTimes {
+		if loaded, ok := s.modTimes[file]; !ok || !loaded.Equal(modTime) {
+			changed = true
+		}
+	}
+	s.lock.Unlock()
+	if !changed {
+		return
+	}
+	log.Info("scripts changed, reloading them")
+	err = s.Reload()
+	if err != nil {
+		log.Errorf("failed to reload scripts: %s", err)
+	}
+}
+
+func (s *Scripts) OnMessage(m twitchirc.PrivateMessage) {
+	s.lock.Lock()
+	scripts := s.scripts
+	s.lock.Unlock()
+	var message starlark.Value
+	for _, sc := range scripts {
+		if sc.onMessage == nil || sc.channels != nil && !sc.channels[m.Channel] {
+			continue
+		}
+		if message == nil {
+			message = messageValue(m)
+		}
+		s.call(sc, sc.onMessage, starlark.Tuple{message})
+	}
+}
+
+func (s *Scripts) call(sc *script, fn starlark.Callable, args starlark.Tuple) {
+	thread, done := s.thread(sc)
+	defer done()
+	_, err := starlark.Call(thread, fn, args, nil)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"script": sc.name,
+		}).Errorf("script failed: %s", err)
+	}
+}
+
+func messageValue(m twitchirc.PrivateMessage) starlark.Value {
+	_, isModerator := m.User.Badges["moderator"]
+	_, isBroadcaster := m.User.Badges["broadcaster"]
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, starlark.StringDict{
+		"id":           starlark.String(m.ID),
+		"channel":      starlark.String(m.Channel),
+		"user":         starlark.String(strings.ToLower(m.User.Name)),
+		"display_name": starlark.String(m.User.DisplayName),
+		"text":         starlark.String(m.Message),
+		"moderator":    starlark.Bool(isModerator || isBroadcaster),
+	})
+}
+
+// builtins are what scripts can call besides the starlark language itself
+func (s *Scripts) builtins(sc *script) starlark.StringDict {
+	builtins := guards()
+	builtins["store"] = sc.store
+	builtins["say"] = starlark.NewBuiltin("say", s.say)
+	builtins["emotes"] = starlark.NewBuiltin("emotes", s.emotes)
+	builtins["is_emote"] = starlark.NewBuiltin("is_emote", s.isEmote)
+	builtins["only_emotes"] = starlark.NewBuiltin("only_emotes", s.onlyEmotes)
+	builtins["now"] = starlark.NewBuiltin("now", s.now)
+	return builtins
+}
+
+// say(channel, text) sends text through the same rate limit and chat mode checks as everything the bot says,
+// to a channel the bot joined and the script runs in
+func (s *Scripts) say(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var channel, text string
+	err := starlark.UnpackArgs(b.Name(), args, kwargs, "channel", &channel, "text", &text)
+	if err != nil {
+		return nil, err
+	}
+	channel = strings.ToLower(channel)
+	sc := thread.Local(scriptKey).(*script)
+	if sc.channels != nil && !sc.channels[channel] {
+		return nil, fmt.Errorf("%s: script doesn't run in %s", b.Name(), channel)
+	}
+	if !s.joined(channel) {
+		return nil, fmt.Errorf("%s: not in channel %s", b.Name(), channel)
+	}
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, fmt.Errorf("%s: empty message", b.Name())
+	}
+	if len(text) > maxSayLength {
+		return nil, fmt.Errorf("%s: message is longer than %d bytes", b.Name(), maxSayLength)
+	}
+	s.host.Say(channel, text)
+	log.WithFields(log.Fields{
+		"script":  thread.Name,
+		"channel": channel,
+		"message": text,
+	}).Info("script said something")
+	return starlark.None, nil
+}
+
+func (s *Scripts) joined(channel string) bool {
+	for _, joined := range s.host.Channels() {
+		if joined == channel {
+			return true
+		}
+	}
+	return false
+}
+
+// emotes(channel) returns the global and channel emotes the bot can use in channel
+func (s *Scripts) emotes(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var channel string
+	err := starlark.UnpackArgs(b.Name(), args, kwargs, "channel", &channel)
+	if err != nil {
+		return nil, err
+	}
+	global, channelEmotes, err := s.host.Emotes().Emotes(channel)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", b.Name(), err)
+	}
+	values := []starlark.Value{}
+	for _, emote := range append(global, channelEmotes...) {
+		values = append(values, starlark.String(emote))
+	}
+	return starlark.NewList(values), nil
+}
+
+// is_emote(word, channel)
+func (s *Scripts) isEmote(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var word, channel string
+	err := starlark.UnpackArgs(b.Name(), args, kwargs, "word", &word, "channel", &channel)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Bool(s.host.Emotes().IsWordAnEmoteInChannel(word, channel)), nil
+}
+
+// only_emotes(text, channel) drops every word of text that isn't an emote
+func (s *Scripts) onlyEmotes(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var text, channel string
+	err := starlark.UnpackArgs(b.Name(), args, kwargs, "text", &text, "channel", &channel)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.String(s.host.Emotes().MessageWithOnlyEmotes(text, channel)), nil
+}
+
+// now() returns the current unix time in seconds
+func (s *Scripts) now(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	err := starlark.UnpackArgs(b.Name(), args, kwargs)
+	if err != nil {
+		return nil, err
+	}
+	return starlark.Float(float64(s.host.Clock().Now().UnixNano()) / float64(time.Second)), nil
+}
@@ -0,0 +1,285 @@
+package scripting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	twitchirc "github.com/gempir/go-twitch-irc/v2"
+	"harubot/clock"
+	"harubot/feature"
+	messagequeue "harubot/message-queue"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeEmotes struct{}
+
+func (f fakeEmotes) Emotes(channel string) ([]string, []string, error) {
+	return []string{"Kappa"}, []string{"KEKW"}, nil
+}
+
+func (f fakeEmotes) IsWordAnEmoteInChannel(word string, channel string) bool {
+	return word == "Kappa" || word == "KEKW"
+}
+
+func (f fakeEmotes) MessageWithOnlyEmotes(message string, channel string) string {
+	emotes := []string{}
+	for _, word := range strings.Fields(message) {
+		if f.IsWordAnEmoteInChannel(word, channel) {
+			emotes = append(emotes, word)
+		}
+	}
+	return strings.Join(emotes, " ")
+}
+
+func (f fakeEmotes) MessageContainsOnlyEmotes(message string, channel string) bool {
+	return f.MessageWithOnlyEmotes(message, channel) == strings.TrimSpace(message)
+}
+
+type fakeHost struct {
+	said  []string
+	clock *clock.Virtual
+}
+
+func (h *fakeHost) Say(channel string, message string) {
+	h.said = append(h.said, fmt.Sprintf("%s: %s", channel, message))
+}
+
+func (h *fakeHost) Emotes() feature.EmoteCache {
+	return fakeEmotes{}
+}
+
+func (h *fakeHost) MessageQueue(channel string) (*messagequeue.MessageQueue, bool) {
+	return nil, false
+}
+
+func (h *fakeHost) Channels() []string {
+	return []string{"forsen", "xqc"}
+}
+
+func (h *fakeHost) Paused(channel string) bool {
+	return false
+}
+
+func (h *fakeHost) SelfUsername() string {
+	return "haruiswaifu"
+}
+
+func (h *fakeHost) Clock() clock.Clock {
+	return h.clock
+}
+
+func (h *fakeHost) Context() context.Context {
+	return context.Background()
+}
+
+func writeScript(t *testing.T, dir string, name string, source string) {
+	err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newScripts(t *testing.T, dir string) (*Scripts, *fakeHost) {
+	host := &fakeHost{clock: clock.NewVirtual(time.Now())}
+	s := New(host).(*Scripts)
+	config, _ := json.Marshal(Config{Directory: dir, MaxSteps: 10000, MaxAllocationBytes: 32 * 1024 * 1024, TimeoutMilliseconds: 1000})
+	err := s.Configure(config)
+	if err != nil {
+		t.Fatalf("Configure() failed: %s", err)
+	}
+	return s, host
+}
+
+func TestScripts_OnMessage(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "counter.star", `
+channels = ["xqc"]
+
+def on_message(msg):
+    for word in msg.text.split(" "):
+        if word == "KEKW":
+            store["kekw"] = store.get("kekw", 0) + 1
+    if msg.text == "!kekw":
+        say(msg.channel, "KEKW was said %d times %s" % (store.get("kekw", 0), only_emotes("so far Kappa", msg.channel)))
+`)
+	writeScript(t, dir, "loop.star", `
+def on_message(msg):
+    for i in range(1000000):
+        pass
+    say(msg.channel, "never gets here")
+`)
+	writeScript(t, dir, "broken.star", `def on_message(msg)`)
+	s, host := newScripts(t, dir)
+
+	for _, m := range []twitchirc.PrivateMessage{
+		{Channel: "xqc", Message: "KEKW KEKW"},
+		{Channel: "forsen", Message: "KEKW"},
+		{Channel: "xqc", Message: "KEKW"},
+		{Channel: "xqc", Message: "!kekw"},
+	} {
+		s.OnMessage(m)
+	}
+
+	want := []string{"xqc: KEKW was said 3 times Kappa"}
+	if !reflect.DeepEqual(host.said, want) {
+		t.Errorf("said %v, want %v", host.said, want)
+	}
+}
+
+func TestScripts_OnTick(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "hello.star", `
+def on_message(msg):
+    say(msg.channel, "hello")
+`)
+	s, host := newScripts(t, dir)
+	writeScript(t, dir, "hello.star", `
+def on_message(msg):
+    say(msg.channel, "hello again")
+`)
+	changed := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "hello.star"), changed, changed)
+
+	s.OnMessage(twitchirc.PrivateMessage{Channel: "xqc"})
+	host.clock.Advance(time.Minute)
+	s.OnTick(host.clock.Now())
+	s.OnMessage(twitchirc.PrivateMessage{Channel: "xqc"})
+
+	want := []string{"xqc: hello", "xqc: hello again"}
+	if !reflect.DeepEqual(host.said, want) {
+		t.Errorf("said %v, want %v", host.said, want)
+	}
+}
+
+func TestScripts_Store(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "alias.star", `
+counts = store
+
+def on_message(msg):
+    counts[msg.user] = counts.get(msg.user, 0) + 1
+    say(msg.channel, "%s: %d" % (msg.user, counts[msg.user]))
+`)
+	writeScript(t, dir, "hoarder.star", `
+def on_message(msg):
+    if msg.text == "list":
+        store["list"] = []
+    elif msg.text == "long":
+        store["long"] = "a" * 2000
+    else:
+        store["short"] = msg.text
+    say(msg.channel, "stored %d" % len(store))
+`)
+	s, host := newScripts(t, dir)
+
+	for _, m := range []twitchirc.PrivateMessage{
+		{Channel: "xqc", User: twitchirc.User{Name: "forsen"}, Message: "list"},
+		{Channel: "xqc", User: twitchirc.User{Name: "forsen"}, Message: "long"},
+		{Channel: "xqc", User: twitchirc.User{Name: "forsen"}, Message: "ok"},
+	} {
+		s.OnMessage(m)
+	}
+
+	want := []string{"xqc: forsen: 1", "xqc: forsen: 2", "xqc: forsen: 3", "xqc: stored 1"}
+	if !reflect.DeepEqual(host.said, want) {
+		t.Errorf("said %v, want %v", host.said, want)
+	}
+}
+
+func TestScripts_SayOnlyInOwnChannels(t *testing.T) {
+	dir := t.TempDir()
+	writeScript(t, dir, "relay.star", `
+channels = ["xqc"]
+
+def on_message(msg):
+    for channel in msg.text.split(" "):
+        say(channel, "hi")
+`)
+	writeScript(t, dir, "everywhere.star", `
+def on_message(msg):
+    for channel in msg.text.split(" "):
+        say(channel, "hello")
+`)
+	s, host := newScripts(t, dir)
+
+	s.OnMessage(twitchirc.PrivateMessage{Channel: "xqc", Message: "xqc"})
+	s.OnMessage(twitchirc.PrivateMessage{Channel: "xqc", Message: "forsen"})
+	s.OnMessage(twitchirc.PrivateMessage{Channel: "xqc", Message: "pokimane"})
+
+	want := []string{"xqc: hello", "xqc: hi", "forsen: hello"}
+	if !reflect.DeepEqual(host.said, want) {
+		t.Errorf("said %v, want %v", host.said, want)
+	}
+}
+
+func TestScripts_ReloadKeepsUnchangedStores(t *testing.T) {
+	dir := t.TempDir()
+	counter := `
+def on_message(msg):
+    store["count"] = store.get("count", 0) + 1
+    say(msg.channel, "%s %d" % (NAME, store["count"]))
+`
+	writeScript(t, dir, "a.star", `NAME = "a"`+counter)
+	writeScript(t, dir, "b.star", `NAME = "b"`+counter)
+	s, host := newScripts(t, dir)
+	s.OnMessage(twitchirc.PrivateMessage{Channel: "xqc"})
+
+	writeScript(t, dir, "b.star", `NAME = "b2"`+counter)
+	changed := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "b.star"), changed, changed)
+	err := s.Reload()
+	if err != nil {
+		t.Fatalf("Reload() failed: %s", err)
+	}
+	s.OnMessage(twitchirc.PrivateMessage{Channel: "xqc"})
+
+	want := []string{"xqc: a 1", "xqc: b 1", "xqc: a 2", "xqc: b2 1"}
+	if !reflect.DeepEqual(host.said, want) {
+		t.Errorf("said %v, want %v", host.said, want)
+	}
+}
+
+func TestScripts_AllocationLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		source  string
+		refused bool
+	}{
+		{"small repetition", `x = "a" * 100`, false},
+		{"concatenation and formatting", "x = \"%s %s\" % (\"a\" + \"b\", \"{} c\".format(\"d\"))\ny = \",\".join([\"a\"] * 3).replace(\",\", \"--\")", false},
+		{"augmented assignment", "def f():\n    x = [1]\n    x += [2]\n    y = {\"a\": \"b\"}\n    y[\"a\"] *= 2\n    y[\"a\"] %= ()\nf()", false},
+		{"string repetition", `x = [("a" * 300000000) for i in range(8)]`, true},
+		{"list repetition", `x = [1] * 100000000`, true},
+		{"augmented repetition", "def f():\n    x = \"a\"\n    x *= 100000000\nf()", true},
+		{"doubling", "def f():\n    x = \"a\" * 1000000\n    for i in range(40):\n        x += x\nf()", true},
+		{"join", "x = \"\".join([\"a\" * 1000000] * 1000)", true},
+		{"replace", "x = (\"a\" * 10000).replace(\"a\", \"b\" * 10000)", true},
+		{"format", "x = (\"{}\" * 1000).format(\"a\" * 100000)", true},
+		{"range materialization", `x = list(range(100000000))`, true},
+		{"repr", `x = str([["a" * 1000000] * 1000] * 1000)`, true},
+		{"copies", "def f():\n    l, x = [1] * 100000, []\n    for i in range(100):\n        x.append(l[:])\nf()", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeScript(t, dir, "script.star", tt.source)
+			s, _ := newScripts(t, dir)
+			start := time.Now()
+			_, err := s.load(filepath.Join(dir, "script.star"))
+			if tt.refused && err == nil {
+				t.Errorf("load() succeeded, want the allocation to be refused")
+			}
+			if !tt.refused && err != nil {
+				t.Errorf("load() failed: %s", err)
+			}
+			if elapsed := time.Since(start); elapsed > time.Second {
+				t.Errorf("load() took %s, longer than the timeout", elapsed)
+			}
+		})
+	}
+}
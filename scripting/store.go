@@ -0,0 +1,93 @@
+package scripting
+
+import (
+	"fmt"
+	"go.starlark.net/starlark"
+)
+
+const (
+	maxStoreEntries    = 10000
+	maxStoreValueBytes = 1024 // of each string key or value, together with maxStoreEntries this bounds a store to about 20MB
+)
+
+// store is the dict a script keeps between calls. Unlike a starlark dict it's never frozen,
+// so scripts may keep it in a global, and it only takes small immutable keys and values,
+// since starlark can't limit memory and the store is the only state that outlives a call.
+type store struct {
+	entries *starlark.Dict
+}
+
+var (
+	_ starlark.IterableMapping = (*store)(nil)
+	_ starlark.HasSetKey       = (*store)(nil)
+	_ starlark.HasAttrs        = (*store)(nil)
+	_ starlark.Sequence        = (*store)(nil)
+)
+
+// storeMethods are the dict methods that can't grow a store, writes only go through SetKey
+var storeMethods = []string{"clear", "get", "items", "keys", "pop", "values"}
+
+func newStore() *store {
+	return &store{entries: starlark.NewDict(0)}
+}
+
+func (s *store) String() string        { return s.entries.String() }
+func (s *store) Type() string          { return "store" }
+func (s *store) Freeze()               {} // stays writable when the globals of its script are frozen
+func (s *store) Truth() starlark.Bool  { return s.entries.Truth() }
+func (s *store) Hash() (uint32, error) { return 0, fmt.Errorf("unhashable type: store") }
+func (s *store) Len() int              { return s.entries.Len() }
+
+func (s *store) Iterate() starlark.Iterator { return s.entries.Iterate() }
+func (s *store) Items() []starlark.Tuple    { return s.entries.Items() }
+
+func (s *store) Get(k starlark.Value) (starlark.Value, bool, error) {
+	return s.entries.Get(k)
+}
+
+func (s *store) SetKey(k, v starlark.Value) error {
+	err := checkStorable(k)
+	if err != nil {
+		return fmt.Errorf("store key: %s", err)
+	}
+	err = checkStorable(v)
+	if err != nil {
+		return fmt.Errorf("store value: %s", err)
+	}
+	if _, found, _ := s.entries.Get(k); !found && s.entries.Len() >= maxStoreEntries {
+		return fmt.Errorf("store is full, it holds at most %d entries", maxStoreEntries)
+	}
+	return s.entries.SetKey(k, v)
+}
+
+func (s *store) Attr(name string) (starlark.Value, error) {
+	for _, method := range storeMethods {
+		if method == name {
+			return s.entries.Attr(name)
+		}
+	}
+	return nil, nil
+}
+
+func (s *store) AttrNames() []string {
+	return storeMethods
+}
+
+// checkStorable allows None, bools, floats, ints that fit in 64 bits and strings of at most maxStoreValueBytes
+func checkStorable(v starlark.Value) error {
+	switch v := v.(type) {
+	case starlark.NoneType, starlark.Bool, starlark.Float:
+		return nil
+	case starlark.Int:
+		if _, ok := v.Int64(); !ok {
+			return fmt.Errorf("int doesn't fit in 64 bits")
+		}
+		return nil
+	case starlark.String:
+		if len(v) > maxStoreValueBytes {
+			return fmt.Errorf("string is longer than %d bytes", maxStoreValueBytes)
+		}
+		return nil
+	}
+	return fmt.Errorf("%s can't be stored, only None, bools, numbers and strings can", v.Type())
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"harubot/credentials"
+	"os"
+)
+
+// runEncryptSecrets converts a plain secrets file into one encrypted with the key in HARUBOT_SECRETS_KEY
+func runEncryptSecrets(args []string) error {
+	flags := flag.NewFlagSet("encrypt-secrets", flag.ExitOnError)
+	in := flags.String("in", "./secrets.json", "path to the plain secrets file")
+	out := flags.String("out", "", "path to write the encrypted secrets to")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *out == "" {
+		return errors.New("no output path given, set -out")
+	}
+	key, ok := os.LookupEnv(credentials.EncryptionKeyVariable)
+	if !ok || key == "" {
+		return fmt.Errorf("%s isn't set", credentials.EncryptionKeyVariable)
+	}
+
+	s, err := credentials.FileStore{Path: *in}.Load()
+	if err != nil {
+		return err
+	}
+	store, err := credentials.NewEncryptedFileStore(*out, key)
+	if err != nil {
+		return err
+	}
+	return store.Save(s)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	log "github.com/sirupsen/logrus"
+	decisionlog "harubot/decision-log"
+	streamstatus "harubot/stream-status"
+	"sort"
+)
+
+// policies lists the configured per-channel offline policies
+func policies(policiesByChannel map[string]string) []string {
+	policies := []string{}
+	for _, policy := range policiesByChannel {
+		policies = append(policies, policy)
+	}
+	return policies
+}
+
+// offlinePolicy returns what the bot does in channel while it's offline
+func (state *state) offlinePolicy(channel string) string {
+	if policy, ok := state.offlinePolicies[channel]; ok {
+		return policy
+	}
+	if state.defaultOfflinePolicy == "" {
+		return streamstatus.PolicyActive
+	}
+	return state.defaultOfflinePolicy
+}
+
+// isQuietOffline tells whether chat in channel should be ignored because it's offline
+func (state *state) isQuietOffline(channel string) bool {
+	if state.streams == nil || state.offlinePolicy(channel) != streamstatus.PolicyQuiet {
+		return false
+	}
+	live, known := state.streams.IsLive(channel)
+	return known && !live
+}
+
+// isLive tells whether channel is known to be live
+func (state *state) isLive(channel string) bool {
+	if state.streams == nil {
+		return false
+	}
+	live, _ := state.streams.IsLive(channel)
+	return live
+}
+
+// polledChannels are the channels the bot is in plus those it left because they went offline
+func (state *state) polledChannels() []string {
+	channels := state.channels()
+	state.autoPartedLock.Lock()
+	for channel := range state.autoParted {
+		channels = append(channels, channel)
+	}
+	state.autoPartedLock.Unlock()
+	sort.Strings(channels)
+	return channels
+}
+
+// onStreamStatusChange leaves channels with the part policy when they go offline and rejoins them when they go live
+func (state *state) onStreamStatusChange(channel string, live bool) {
+	reason := "went offline"
+	if live {
+		reason = "went live"
+	}
+	log.WithFields(log.Fields{
+		"channel": channel,
+		"live":    live,
+	}).Info("stream status changed")
+	state.decide(channel, decisionlog.ActionStream, "", reason)
+	if state.offlinePolicy(channel) != streamstatus.PolicyPart {
+		return
+	}
+
+	state.autoPartedLock.Lock()
+	defer state.autoPartedLock.Unlock()
+	if live && state.autoParted[channel] {
+		delete(state.autoParted, channel)
+		state.trackChannel(channel) // its events were kept subscribed to while it was offline
+		state.client.Join(channel)
+		log.Infof("rejoined channel #%s", channel)
+	} else if !live && state.untrackChannel(channel) {
+		state.autoParted[channel] = true
+		state.client.Depart(channel)
+		log.Infof("left offline channel #%s", channel)
+	}
+}
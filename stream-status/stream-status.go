@@ -0,0 +1,135 @@
+package streamstatus
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"harubot/clock"
+	twitchapi "harubot/twitch-api"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Offline policies decide what the bot does in a channel while it's not live
+const (
+	PolicyActive = "active" // behave the same as while live
+	PolicyQuiet  = "quiet"  // stay in the channel but ignore its chat
+	PolicyPart   = "part"   // leave the channel and rejoin once it goes live
+)
+
+// ValidPolicy tells whether policy is one of the offline policies, an empty one meaning PolicyActive
+func ValidPolicy(policy string) bool {
+	switch policy {
+	case "", PolicyActive, PolicyQuiet, PolicyPart:
+		return true
+	default:
+		return false
+	}
+}
+
+// StreamsGetter is the part of the Helix client the tracker needs
+type StreamsGetter interface {
+	GetStreams(ctx context.Context, userLogins []string) ([]twitchapi.Stream, error)
+}
+
+// Tracker polls which channels are live and notifies subscribers when that changes
+type Tracker struct {
+	api         StreamsGetter
+	channels    func() []string
+	clock       clock.Clock
+	live        map[string]bool // only has channels that have been polled at least once
+	subscribers []func(channel string, live bool)
+	lock        sync.Mutex
+}
+
+// NewTracker creates a tracker for the channels returned by channels, which is asked again before every poll
+func NewTracker(api StreamsGetter, channels func() []string, clk clock.Clock) *Tracker {
+	return &Tracker{
+		api:      api,
+		channels: channels,
+		clock:    clk,
+		live:     map[string]bool{},
+	}
+}
+
+// Subscribe registers onChange to be called whenever a channel goes live or offline, including the first time it's polled
+func (t *Tracker) Subscribe(onChange func(channel string, live bool)) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.subscribers = append(t.subscribers, onChange)
+}
+
+// IsLive reports whether channel is live, and whether that is known yet at all
+func (t *Tracker) IsLive(channel string) (live bool, known bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	live, known = t.live[channel]
+	return live, known
+}
+
+// RoutinelyPoll polls the status of all channels every interval
+func (t *Tracker) RoutinelyPoll(ctx context.Context, interval time.Duration) {
+	for {
+		err := t.Poll(ctx)
+		if err != nil {
+			log.Errorf("failed to poll stream status: %s", err)
+		}
+		if clock.SleepContext(ctx, t.clock, interval) != nil {
+			return
+		}
+	}
+}
+
+// Poll updates the status of all channels once
+func (t *Tracker) Poll(ctx context.Context) error {
+	channels := t.channels()
+	live := map[string]bool{}
+	for start := 0; start < len(channels); start += twitchapi.MaxStreamsPerRequest {
+		end := start + twitchapi.MaxStreamsPerRequest
+		if end > len(channels) {
+			end = len(channels)
+		}
+		streams, err := t.api.GetStreams(ctx, channels[start:end])
+		if err != nil {
+			return err
+		}
+		for _, stream := range streams {
+			if stream.Type == "live" {
+				live[strings.ToLower(stream.UserLogin)] = true
+			}
+		}
+	}
+
+	t.update(channels, live)
+	return nil
+}
+
+// Set records the status of channel learned some other way than polling, e.g. from EventSub
+func (t *Tracker) Set(channel string, live bool) {
+	t.update([]string{channel}, map[string]bool{channel: live})
+}
+
+// update records the status of channels, those missing from live are offline, and notifies subscribers of changes
+func (t *Tracker) update(channels []string, live map[string]bool) {
+	type change struct {
+		channel string
+		live    bool
+	}
+	changes := []change{}
+	t.lock.Lock()
+	for _, channel := range channels {
+		wasLive, known := t.live[channel]
+		if !known || wasLive != live[channel] {
+			changes = append(changes, change{channel, live[channel]})
+		}
+		t.live[channel] = live[channel]
+	}
+	subscribers := append([]func(string, bool){}, t.subscribers...)
+	t.lock.Unlock()
+
+	for _, c := range changes {
+		for _, onChange := range subscribers {
+			onChange(c.channel, c.live)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package streamstatus
+
+import (
+	"context"
+	"fmt"
+	"harubot/clock"
+	twitchapi "harubot/twitch-api"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeStreams struct {
+	live     map[string]bool
+	requests int
+}
+
+func (f *fakeStreams) GetStreams(ctx context.Context, userLogins []string) ([]twitchapi.Stream, error) {
+	f.requests++
+	streams := []twitchapi.Stream{}
+	for _, login := range userLogins {
+		if f.live[login] {
+			streams = append(streams, twitchapi.Stream{UserLogin: login, Type: "live"})
+		}
+	}
+	return streams, nil
+}
+
+func TestTracker_Poll(t *testing.T) {
+	channels := []string{"xqc", "forsen"}
+	for i := 0; i < 150; i++ {
+		channels = append(channels, fmt.Sprintf("channel%d", i))
+	}
+	api := &fakeStreams{live: map[string]bool{"xqc": true}}
+	tr := NewTracker(api, func() []string { return channels }, clock.NewVirtual(time.Now()))
+	changes := []string{}
+	tr.Subscribe(func(channel string, live bool) {
+		if channel == "xqc" || channel == "forsen" {
+			changes = append(changes, fmt.Sprintf("%s %v", channel, live))
+		}
+	})
+
+	if _, known := tr.IsLive("xqc"); known {
+		t.Errorf("IsLive() is known before polling")
+	}
+	polls := []map[string]bool{
+		{"xqc": true},
+		{"xqc": true},
+		{"forsen": true},
+	}
+	for _, live := range polls {
+		api.live = live
+		err := tr.Poll(context.Background())
+		if err != nil {
+			t.Fatalf("Poll() failed: %s", err)
+		}
+	}
+
+	want := []string{"xqc true", "forsen false", "xqc false", "forsen true"}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes = %v, want %v", changes, want)
+	}
+	if live, known := tr.IsLive("forsen"); !live || !known {
+		t.Errorf("IsLive(forsen) = %v, %v, want true, true", live, known)
+	}
+	if api.requests != 6 {
+		t.Errorf("requests = %d, want 2 per poll of %d channels", api.requests, len(channels))
+	}
+}
@@ -0,0 +1,88 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// Supervisor runs the bot's background routines under one context that is cancelled on SIGINT/SIGTERM or Stop
+type Supervisor struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	stopSignal func()
+	wg         sync.WaitGroup
+	err        error
+	lock       sync.Mutex
+}
+
+func New() *Supervisor {
+	signalCtx, stopSignal := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	ctx, cancel := context.WithCancel(signalCtx)
+	return &Supervisor{
+		ctx:        ctx,
+		cancel:     cancel,
+		stopSignal: stopSignal,
+	}
+}
+
+// Context is cancelled as soon as the bot should shut down
+func (s *Supervisor) Context() context.Context {
+	return s.ctx
+}
+
+// Go runs routine in the background until it returns, recovering it from panics so one routine can't take the rest down uncleanly
+func (s *Supervisor) Go(name string, routine func(ctx context.Context)) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer func() {
+			if r := recover(); r != nil {
+				s.Stop(fmt.Errorf("%s panicked: %v", name, r))
+			}
+		}()
+		routine(s.ctx)
+		log.WithFields(log.Fields{
+			"routine": name,
+		}).Debug("routine stopped")
+	}()
+}
+
+// Stop starts shutting down, err is the reason for an unclean shutdown and nil otherwise.
+// Only the first reason is kept.
+func (s *Supervisor) Stop(err error) {
+	s.lock.Lock()
+	if s.err == nil && s.ctx.Err() == nil {
+		s.err = err
+	}
+	s.lock.Unlock()
+	s.cancel()
+}
+
+// Err returns the reason passed to the first Stop
+func (s *Supervisor) Err() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.err
+}
+
+// Wait waits for all routines to return, giving up after timeout. It returns whether all routines returned.
+func (s *Supervisor) Wait(timeout time.Duration) bool {
+	defer s.stopSignal()
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
@@ -0,0 +1,81 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSupervisor_RecoversPanics(t *testing.T) {
+	s := New()
+	stopped := make(chan struct{})
+	s.Go("waiting", func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+	})
+	s.Go("panicking", func(ctx context.Context) {
+		panic("oh no")
+	})
+
+	if !s.Wait(time.Second) {
+		t.Fatal("Wait() gave up, a panic should stop every routine")
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Error("the other routine wasn't stopped")
+	}
+	if err := s.Err(); err == nil || !strings.Contains(err.Error(), "panicking panicked: oh no") {
+		t.Errorf("Err() = %v, want the panic", err)
+	}
+}
+
+func TestSupervisor_StopAndWait(t *testing.T) {
+	s := New()
+	order := make(chan string, 3)
+	for _, name := range []string{"a", "b"} {
+		name := name
+		s.Go(name, func(ctx context.Context) {
+			<-ctx.Done()
+			time.Sleep(10 * time.Millisecond)
+			order <- name
+		})
+	}
+
+	s.Stop(errors.New("first"))
+	s.Stop(errors.New("second"))
+	returned := s.Wait(time.Second)
+	order <- "wait"
+
+	if !returned {
+		t.Fatal("Wait() gave up although every routine returns once stopped")
+	}
+	if got := []string{<-order, <-order, <-order}; got[2] != "wait" {
+		t.Errorf("Wait() returned before its routines: %v", got)
+	}
+	if err := s.Err(); err == nil || err.Error() != "first" {
+		t.Errorf("Err() = %v, want the reason of the first Stop", err)
+	}
+	if s.Context().Err() == nil {
+		t.Error("Context() wasn't cancelled by Stop")
+	}
+}
+
+func TestSupervisor_WaitGivesUp(t *testing.T) {
+	s := New()
+	release := make(chan struct{})
+	defer close(release)
+	s.Go("stuck", func(ctx context.Context) {
+		<-release
+	})
+
+	s.Stop(nil)
+	if s.Wait(10 * time.Millisecond) {
+		t.Error("Wait() = true, want it to give up on a routine ignoring its context")
+	}
+	if err := s.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil for a clean shutdown", err)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"harubot/admin"
+	"harubot/dashboard"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// terminalSize reads the size of the terminal from the environment most shells export it to, falling back to fallback
+func terminalSize(variable string, fallback int) int {
+	size, err := strconv.Atoi(os.Getenv(variable))
+	if err != nil || size <= 0 {
+		return fallback
+	}
+	return size
+}
+
+// runTUI shows a live dashboard of a running bot, read from its admin API
+func runTUI(args []string) error {
+	flags := flag.NewFlagSet("tui", flag.ExitOnError)
+	address := flags.String("address", "", "admin api address of the bot, defaults to admin-address in -env")
+	token := flags.String("token", "", "bearer token of the admin api, defaults to admin-token in -env")
+	envPath := flags.String("env", "./env.json", "path to the environment variables of the bot")
+	interval := flags.Duration("interval", time.Second, "how often to refresh")
+	width := flags.Int("width", terminalSize("COLUMNS", 120), "width of the terminal")
+	height := flags.Int("height", terminalSize("LINES", 40), "height of the terminal")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *address == "" || *token == "" {
+		e := &environmentVariables{}
+		err = readJSON(*envPath, e)
+		if err != nil && *address == "" {
+			return err
+		}
+		if *address == "" {
+			*address = e.AdminAddress
+		}
+		if *token == "" {
+			*token = e.AdminToken
+		}
+	}
+	if *address == "" {
+		return errors.New("no admin api address given, set -address or admin-address")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return dashboard.New(admin.NewClient(*address, *token), os.Stdout, *width, *height).Run(ctx, *interval)
+}
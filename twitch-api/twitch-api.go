@@ -0,0 +1,212 @@
+package twitchapi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"harubot/credentials"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const DefaultBaseURL = "https://api.twitch.tv/helix"
+
+// Client calls Helix endpoints the helix library doesn't cover, always with the current user access token
+type Client struct {
+	baseURL     string
+	credentials *credentials.Provider
+	httpClient  *http.Client
+}
+
+// NewClient creates a client for the Helix API at baseURL, or DefaultBaseURL if it's empty
+func NewClient(baseURL string, provider *credentials.Provider) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		baseURL:     baseURL,
+		credentials: provider,
+		httpClient:  &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// Error is a non-successful response from Helix
+type Error struct {
+	StatusCode int
+	Message    string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("helix responded with %d: %s", e.StatusCode, e.Message)
+}
+
+// do sends a request to path and decodes a successful JSON response into response, if it isn't nil
+func (c *Client) do(ctx context.Context, method string, path string, query url.Values, body io.Reader, response any) error {
+	endpoint := fmt.Sprintf("%s%s", c.baseURL, path)
+	if len(query) > 0 {
+		endpoint = fmt.Sprintf("%s?%s", endpoint, query.Encode())
+	}
+	request, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	s := c.credentials.Get()
+	request.Header.Add("Client-Id", s.TwitchClientID)
+	request.Header.Add("Authorization", fmt.Sprintf("Bearer %s", s.TwitchAccessToken))
+	if body != nil {
+		request.Header.Add("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(request)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if json.Unmarshal(bodyBytes, apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = string(bodyBytes)
+		}
+		return apiErr
+	}
+	if response == nil || len(bodyBytes) == 0 {
+		return nil
+	}
+	return json.Unmarshal(bodyBytes, response)
+}
+
+// UpdateUserChatColor sets the chat color of userID to a named color or, for Turbo and Prime users, a hex color
+func (c *Client) UpdateUserChatColor(ctx context.Context, userID string, color string) error {
+	query := url.Values{}
+	query.Set("user_id", userID)
+	query.Set("color", color)
+	return c.do(ctx, http.MethodPut, "/chat/color", query, nil, nil)
+}
+
+// MaxStreamsPerRequest is how many channels GetStreams can ask about at once
+const MaxStreamsPerRequest = 100
+
+type Stream struct {
+	UserLogin   string    `json:"user_login"`
+	Type        string    `json:"type"`
+	Title       string    `json:"title"`
+	ViewerCount int       `json:"viewer_count"`
+	StartedAt   time.Time `json:"started_at"`
+}
+
+type getStreamsResponse struct {
+	Data []Stream `json:"data"`
+}
+
+// GetStreams returns the live streams of up to MaxStreamsPerRequest channels, offline channels are left out
+func (c *Client) GetStreams(ctx context.Context, userLogins []string) ([]Stream, error) {
+	if len(userLogins) > MaxStreamsPerRequest {
+		return nil, fmt.Errorf("can only get %d streams at once", MaxStreamsPerRequest)
+	}
+	query := url.Values{}
+	for _, login := range userLogins {
+		query.Add("user_login", login)
+	}
+	query.Set("first", strconv.Itoa(MaxStreamsPerRequest))
+	response := getStreamsResponse{}
+	err := c.do(ctx, http.MethodGet, "/streams", query, nil, &response)
+	if err != nil {
+		return nil, err
+	}
+	return response.Data, nil
+}
+
+type EventSubTransport struct {
+	Method    string `json:"method"`
+	SessionID string `json:"session_id"`
+}
+
+// EventSubSubscription asks for the events of one type, see https://dev.twitch.tv/docs/eventsub/eventsub-subscription-types
+type EventSubSubscription struct {
+	Type      string            `json:"type"`
+	Version   string            `json:"version"`
+	Condition map[string]string `json:"condition"`
+	Transport EventSubTransport `json:"transport"`
+}
+
+type createEventSubSubscriptionResponse struct {
+	Data []struct {
+		ID string `json:"id"`
+	} `json:"data"`
+}
+
+// CreateEventSubSubscription subscribes to events, which for websocket transports are sent to the session given in the transport.
+// It returns the id of the subscription.
+func (c *Client) CreateEventSubSubscription(ctx context.Context, subscription EventSubSubscription) (string, error) {
+	body, err := json.Marshal(subscription)
+	if err != nil {
+		return "", err
+	}
+	response := createEventSubSubscriptionResponse{}
+	err = c.do(ctx, http.MethodPost, "/eventsub/subscriptions", nil, bytes.NewReader(body), &response)
+	if err != nil {
+		return "", err
+	}
+	if len(response.Data) == 0 {
+		return "", fmt.Errorf("helix didn't return the created subscription")
+	}
+	return response.Data[0].ID, nil
+}
+
+// DeleteEventSubSubscription unsubscribes from the events of the subscription with id
+func (c *Client) DeleteEventSubSubscription(ctx context.Context, id string) error {
+	query := url.Values{}
+	query.Set("id", id)
+	return c.do(ctx, http.MethodDelete, "/eventsub/subscriptions", query, nil, nil)
+}
+
+// ChatMessage is a message to send through Helix instead of IRC
+type ChatMessage struct {
+	BroadcasterID        string `json:"broadcaster_id"`
+	SenderID             string `json:"sender_id"`
+	Message              string `json:"message"`
+	ReplyParentMessageID string `json:"reply_parent_message_id,omitempty"` // makes the message a threaded reply
+}
+
+type sendChatMessageResponse struct {
+	Data []struct {
+		MessageID  string `json:"message_id"`
+		IsSent     bool   `json:"is_sent"`
+		DropReason *struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"drop_reason"`
+	} `json:"data"`
+}
+
+// SendChatMessage sends message as its sender, which has to be the user of the token, and fails if Twitch dropped it
+func (c *Client) SendChatMessage(ctx context.Context, message ChatMessage) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	response := sendChatMessageResponse{}
+	err = c.do(ctx, http.MethodPost, "/chat/messages", nil, bytes.NewReader(body), &response)
+	if err != nil {
+		return err
+	}
+	if len(response.Data) == 0 {
+		return fmt.Errorf("helix didn't say whether the message was sent")
+	}
+	if result := response.Data[0]; !result.IsSent {
+		if result.DropReason != nil {
+			return fmt.Errorf("message was dropped: %s (%s)", result.DropReason.Message, result.DropReason.Code)
+		}
+		return fmt.Errorf("message was dropped")
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package userstate
+
+import (
+	roomstate "harubot/room-state"
+	"sync"
+)
+
+// Status is what the bot is in a channel, according to its badges there
+type Status struct {
+	Moderator   bool `json:"moderator"`
+	VIP         bool `json:"vip"`
+	Broadcaster bool `json:"broadcaster"`
+	Subscriber  bool `json:"subscriber"`
+}
+
+// statusFromBadges only looks at which badges there are, their versions are e.g. subscriber/0 in the first month
+func statusFromBadges(badges map[string]int) Status {
+	has := func(badge string) bool {
+		_, ok := badges[badge]
+		return ok
+	}
+	return Status{
+		Moderator:   has("moderator"),
+		VIP:         has("vip"),
+		Broadcaster: has("broadcaster"),
+		Subscriber:  has("subscriber") || has("founder"),
+	}
+}
+
+// Elevated tells whether the bot gets the higher rate limit and is exempt from slow mode
+func (s Status) Elevated() bool {
+	return s.Moderator || s.VIP || s.Broadcaster
+}
+
+// Exempt returns the modes without the restrictions that don't apply to the bot
+func (s Status) Exempt(modes roomstate.Modes) roomstate.Modes {
+	if s.Moderator || s.Broadcaster {
+		modes.EmoteOnly = false
+		modes.R9K = false
+	}
+	if s.Elevated() {
+		modes.Slow = 0
+		modes.FollowersOnly = -1
+		modes.NotFollowing = false
+	}
+	if s.Elevated() || s.Subscriber {
+		modes.SubsOnly = false
+	}
+	return modes
+}
+
+// Tracker keeps the status of the bot in every channel from USERSTATE and GLOBALUSERSTATE messages
+type Tracker struct {
+	global    Status
+	byChannel map[string]Status
+	lock      sync.Mutex
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		byChannel: map[string]Status{},
+	}
+}
+
+// UpdateGlobal applies the badges of a GLOBALUSERSTATE, sent once after logging in
+func (t *Tracker) UpdateGlobal(badges map[string]int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.global = statusFromBadges(badges)
+}
+
+// Update applies the badges of a USERSTATE, sent after joining channel and after every message the bot sends there
+func (t *Tracker) Update(channel string, badges map[string]int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.byChannel[channel] = statusFromBadges(badges)
+}
+
+// Get returns the status of the bot in channel, or its global one until the channel's USERSTATE arrived
+func (t *Tracker) Get(channel string) Status {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	s, ok := t.byChannel[channel]
+	if !ok {
+		return t.global
+	}
+	return s
+}
@@ -0,0 +1,41 @@
+package userstate
+
+import (
+	roomstate "harubot/room-state"
+	"testing"
+)
+
+func TestStatus_Exempt(t *testing.T) {
+	restricted := roomstate.Modes{EmoteOnly: true, FollowersOnly: 10, R9K: true, Slow: 30, SubsOnly: true}
+	tests := []struct {
+		name   string
+		badges map[string]int
+		want   roomstate.Modes
+	}{
+		{"no badges", map[string]int{}, restricted},
+		{"subscriber", map[string]int{"subscriber": 12}, roomstate.Modes{EmoteOnly: true, FollowersOnly: 10, R9K: true, Slow: 30}},
+		{"founder", map[string]int{"founder": 0}, roomstate.Modes{EmoteOnly: true, FollowersOnly: 10, R9K: true, Slow: 30}},
+		{"vip", map[string]int{"vip": 1}, roomstate.Modes{EmoteOnly: true, FollowersOnly: -1, R9K: true}},
+		{"moderator", map[string]int{"moderator": 1}, roomstate.Modes{FollowersOnly: -1}},
+		{"broadcaster", map[string]int{"broadcaster": 1}, roomstate.Modes{FollowersOnly: -1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := statusFromBadges(tt.badges).Exempt(restricted); got != tt.want {
+				t.Errorf("Exempt() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTracker_Get(t *testing.T) {
+	tr := NewTracker()
+	tr.UpdateGlobal(map[string]int{"subscriber": 1})
+	tr.Update("xqc", map[string]int{"moderator": 1})
+	if got := tr.Get("xqc"); !got.Moderator || got.Subscriber {
+		t.Errorf("Get(xqc) = %+v, want only moderator", got)
+	}
+	if got := tr.Get("forsen"); !got.Subscriber {
+		t.Errorf("Get(forsen) = %+v, want the global status", got)
+	}
+}